@@ -0,0 +1,157 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Cache keeps the objects of a namespace in memory through shared
+// informers, so that every user of a resource shares one list and watch.
+// Request the listers and add the handlers before Start; resources
+// requested later are started by calling Start again.
+type Cache struct {
+	factory   informers.SharedInformerFactory
+	namespace string
+	selector  string
+
+	podSelector      string
+	podFieldSelector string
+}
+
+// NewCache returns a cache of the namespace and label selector of opts,
+// or of all namespaces with WithAllNamespaces. Handlers get every object
+// again each resync period, zero disables resyncs.
+func NewCache(client kubernetes.Interface, resync time.Duration, opts ...KubeOption) *Cache {
+	o := NewKubeOptions(opts)
+	factory := informers.NewSharedInformerFactoryWithOptions(client, resync,
+		informers.WithNamespace(o.listNamespace()),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = o.LabelSelector
+		}),
+	)
+	return &Cache{
+		factory:   factory,
+		namespace: o.listNamespace(),
+		selector:  o.LabelSelector,
+	}
+}
+
+// Namespace returns the namespace of the cache, metav1.NamespaceAll for
+// all namespaces.
+func (c *Cache) Namespace() string {
+	return c.namespace
+}
+
+// FilterPods narrows the pods of the cache to the label and field
+// selectors of opts, on top of the label selector of the cache. Call it
+// before the pods are requested.
+func (c *Cache) FilterPods(opts ...KubeOption) {
+	o := NewKubeOptions(opts)
+	c.podSelector = MergeSelectors(c.podSelector, o.LabelSelector)
+	c.podFieldSelector = MergeSelectors(c.podFieldSelector, o.FieldSelector)
+}
+
+func (c *Cache) podInformer() cache.SharedIndexInformer {
+	return c.factory.InformerFor(&corev1.Pod{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredPodInformer(client, c.namespace, resync,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			func(options *metav1.ListOptions) {
+				options.LabelSelector = MergeSelectors(c.selector, c.podSelector)
+				options.FieldSelector = c.podFieldSelector
+			},
+		)
+	})
+}
+
+// Factory returns the informer factory of the cache for the resources
+// without a helper.
+func (c *Cache) Factory() informers.SharedInformerFactory {
+	return c.factory
+}
+
+// Start starts the informers requested so far until ctx is done.
+func (c *Cache) Start(ctx context.Context) {
+	c.factory.Start(ctx.Done())
+}
+
+// WaitForSync waits until the informers have listed their resources and
+// fails when ctx is done first.
+func (c *Cache) WaitForSync(ctx context.Context) error {
+	notSynced := []string{}
+	for t, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			notSynced = append(notSynced, typeName(t))
+		}
+	}
+	if len(notSynced) > 0 {
+		return fmt.Errorf("cache not synced: %s", strings.Join(notSynced, ", "))
+	}
+	return nil
+}
+
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func (c *Cache) Pods() corelisters.PodLister {
+	return corelisters.NewPodLister(c.podInformer().GetIndexer())
+}
+
+func (c *Cache) Services() corelisters.ServiceLister {
+	return c.factory.Core().V1().Services().Lister()
+}
+
+func (c *Cache) Endpoints() corelisters.EndpointsLister {
+	return c.factory.Core().V1().Endpoints().Lister()
+}
+
+func (c *Cache) ConfigMaps() corelisters.ConfigMapLister {
+	return c.factory.Core().V1().ConfigMaps().Lister()
+}
+
+func (c *Cache) Deployments() appslisters.DeploymentLister {
+	return c.factory.Apps().V1().Deployments().Lister()
+}
+
+func (c *Cache) StatefulSets() appslisters.StatefulSetLister {
+	return c.factory.Apps().V1().StatefulSets().Lister()
+}
+
+func (c *Cache) ReplicaSets() appslisters.ReplicaSetLister {
+	return c.factory.Apps().V1().ReplicaSets().Lister()
+}
+
+// AddPodHandler subscribes handler to the pod events.
+func (c *Cache) AddPodHandler(handler cache.ResourceEventHandler) {
+	c.podInformer().AddEventHandler(handler)
+}
+
+// AddServiceHandler subscribes handler to the service events.
+func (c *Cache) AddServiceHandler(handler cache.ResourceEventHandler) {
+	c.factory.Core().V1().Services().Informer().AddEventHandler(handler)
+}
+
+// DeletedObject returns the object of a delete event, which may be
+// wrapped in a cache.DeletedFinalStateUnknown when the watch missed the
+// deletion.
+func DeletedObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
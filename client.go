@@ -2,6 +2,7 @@ package kube
 
 import (
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
@@ -13,3 +14,13 @@ func GetClient(restClientGetter genericclioptions.RESTClientGetter) (corev1clien
 	}
 	return corev1client.NewForConfig(clientConfig)
 }
+
+// GetClientset returns a client for every API group, as needed by the
+// helpers of other resource kinds and by Cache.
+func GetClientset(restClientGetter genericclioptions.RESTClientGetter) (kubernetes.Interface, error) {
+	clientConfig, err := restClientGetter.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(clientConfig)
+}
@@ -7,12 +7,15 @@ import (
 	"github.com/josudoey/kube"
 	"github.com/josudoey/kube/kubeutil"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 )
 
 type KubeInfoPodImageOptions struct {
 	LabelSelector string
+	FieldSelector string
+	AllNamespaces bool
 }
 
 func NewKubeInfoPodImageOptions() *KubeInfoPodImageOptions {
@@ -21,35 +24,51 @@ func NewKubeInfoPodImageOptions() *KubeInfoPodImageOptions {
 
 func (o *KubeInfoPodImageOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	selector := o.LabelSelector
+	fieldSelector := o.FieldSelector
 	namespace, _, err := f.ToRawKubeConfigLoader().Namespace()
 	if err != nil {
 		return err
 	}
 
-	client, err := kube.GetClient(f)
-	if err != nil {
-		return err
+	if len(args) > 0 {
+		podSelector, podFieldSelector, err := kube.GetPodSelectorFor(f, namespace, "pods", args[0])
+		if err != nil {
+			return err
+		}
+		selector = kube.MergeSelectors(selector, podSelector.String())
+		fieldSelector = kube.MergeSelectors(fieldSelector, podFieldSelector.String())
 	}
 
-	ctx := context.Background()
-	pods, err := kube.GetPodList(ctx, client,
-		kube.WithNamespace(namespace),
-		kube.WithLabelSelector(selector),
-	)
+	client, err := kube.GetClient(f)
 	if err != nil {
 		return err
 	}
 
-	for _, pod := range pods.Items {
+	printImages := func(pod *corev1.Pod) error {
+		name := pod.Name
+		if o.AllNamespaces {
+			name = pod.Namespace + "\t" + name
+		}
 		for _, container := range pod.Spec.InitContainers {
-			fmt.Printf("%v\t%v\n", pod.Name, container.Image)
+			fmt.Printf("%v\t%v\n", name, container.Image)
 		}
 		for _, container := range pod.Spec.Containers {
-			fmt.Printf("%v\t%v\n", pod.Name, container.Image)
+			fmt.Printf("%v\t%v\n", name, container.Image)
 		}
+		return nil
 	}
 
-	return nil
+	opts := []kube.KubeOption{
+		kube.WithNamespace(namespace),
+		kube.WithLabelSelector(selector),
+		kube.WithFieldSelector(fieldSelector),
+	}
+	if o.AllNamespaces {
+		opts = append(opts, kube.WithAllNamespaces())
+	}
+
+	ctx := context.Background()
+	return kube.EachPod(ctx, client, printImages, opts...)
 }
 
 func NewCommand() *cobra.Command {
@@ -57,12 +76,15 @@ func NewCommand() *cobra.Command {
 	f := kubeutil.DefaultFactory()
 
 	cmd := &cobra.Command{
-		Use: "pod-image",
+		Use:  "pod-image [TYPE/NAME]",
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdutil.CheckErr(o.Run(f, cmd, args))
 		},
 	}
 
 	cmd.Flags().StringVarP(&o.LabelSelector, "selector", "l", o.LabelSelector, "Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+	cmd.Flags().StringVar(&o.FieldSelector, "field-selector", o.FieldSelector, "Selector (field query) to filter on, supports '=', '==', and '!='.(e.g. --field-selector key1=value1,key2=value2). The server only supports a limited number of field queries per type.")
+	cmd.Flags().BoolVarP(&o.AllNamespaces, "all-namespaces", "A", o.AllNamespaces, "If present, list the requested object(s) across all namespaces. Namespace in current context is ignored even if specified with --namespace.")
 	return cmd
 }
@@ -13,6 +13,8 @@ import (
 
 type PodWatcherOptions struct {
 	LabelSelector string
+	FieldSelector string
+	AllNamespaces bool
 }
 
 func NewPodWatcherOptions() *PodWatcherOptions {
@@ -21,30 +23,42 @@ func NewPodWatcherOptions() *PodWatcherOptions {
 
 func (o *PodWatcherOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	selector := o.LabelSelector
+	fieldSelector := o.FieldSelector
 	namespace, _, err := f.ToRawKubeConfigLoader().Namespace()
 	if err != nil {
 		return err
 	}
 
+	if len(args) > 0 {
+		podSelector, podFieldSelector, err := kube.GetPodSelectorFor(f, namespace, "pods", args[0])
+		if err != nil {
+			return err
+		}
+		selector = kube.MergeSelectors(selector, podSelector.String())
+		fieldSelector = kube.MergeSelectors(fieldSelector, podFieldSelector.String())
+	}
+
 	client, err := kube.GetClient(f)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
-	podList, err := kube.GetPodList(ctx, client,
+	opts := []kube.KubeOption{
 		kube.WithNamespace(namespace),
 		kube.WithLabelSelector(selector),
-	)
+		kube.WithFieldSelector(fieldSelector),
+	}
+	if o.AllNamespaces {
+		opts = append(opts, kube.WithAllNamespaces())
+	}
+
+	ctx := context.Background()
+	podList, err := kube.GetPodList(ctx, client, opts...)
 	if err != nil {
 		return err
 	}
 
-	watcher, err := kube.GetPodWatcher(ctx, client,
-		kube.WithNamespace(namespace),
-		kube.WithLabelSelector(selector),
-		kube.WithResourceVersion(podList.ResourceVersion),
-	)
+	watcher, err := kube.GetRetryPodWatcherFromList(ctx, client, podList, opts...)
 	if err != nil {
 		return err
 	}
@@ -59,7 +73,11 @@ func (o *PodWatcherOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []st
 		if kube.IsPodReady(pod) {
 			ready = "(Ready)"
 		}
-		log.Printf("Event: %s %s%v %s", e.Type, pod.Status.Phase, ready, pod.Name)
+		name := pod.Name
+		if o.AllNamespaces {
+			name = pod.Namespace + " " + name
+		}
+		log.Printf("Event: %s %s%v %s", e.Type, pod.Status.Phase, ready, name)
 	}
 	return nil
 }
@@ -69,12 +87,15 @@ func main() {
 	f := kubeutil.DefaultFactory()
 
 	cmd := &cobra.Command{
-		Use: "kube-pod-watcher",
+		Use:  "kube-pod-watcher [TYPE/NAME]",
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdutil.CheckErr(o.Run(f, cmd, args))
 		},
 	}
 
 	cmd.Flags().StringVarP(&o.LabelSelector, "selector", "l", o.LabelSelector, "Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+	cmd.Flags().StringVar(&o.FieldSelector, "field-selector", o.FieldSelector, "Selector (field query) to filter on, supports '=', '==', and '!='.(e.g. --field-selector key1=value1,key2=value2). The server only supports a limited number of field queries per type.")
+	cmd.Flags().BoolVarP(&o.AllNamespaces, "all-namespaces", "A", o.AllNamespaces, "If present, list the requested object(s) across all namespaces. Namespace in current context is ignored even if specified with --namespace.")
 	cmd.Execute()
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 
+	"github.com/josudoey/kube/cmd/kube-vhost/vhostreplay"
 	"github.com/josudoey/kube/cmd/kube-vhost/vhostserver"
 	"github.com/josudoey/kube/cmd/kube-vhost/vhostshow"
 	"github.com/spf13/cobra"
@@ -19,6 +20,7 @@ func newCommand() *cobra.Command {
 	}
 	root.AddCommand(vhostserver.NewCommand())
 	root.AddCommand(vhostshow.NewCommand())
+	root.AddCommand(vhostreplay.NewCommand())
 	return root
 }
 
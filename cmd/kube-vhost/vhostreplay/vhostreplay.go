@@ -0,0 +1,92 @@
+package vhostreplay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/josudoey/kube/vhost/har"
+	"github.com/spf13/cobra"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+const (
+	defaultPort    = 8010
+	defaultAddress = "127.0.0.1"
+)
+
+type KubeVhostReplayOptions struct {
+	port    int
+	address string
+	dir     string
+}
+
+func NewKubeVhostReplayOptions() *KubeVhostReplayOptions {
+	return &KubeVhostReplayOptions{
+		port:    defaultPort,
+		address: defaultAddress,
+	}
+}
+
+func (o *KubeVhostReplayOptions) Run(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		o.dir = args[0]
+	}
+	if o.dir == "" {
+		return fmt.Errorf("a directory recorded with `kube-vhost server --record` is required")
+	}
+
+	archives, err := har.LoadDir(o.dir)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no %s files in %s", har.Ext, o.dir)
+	}
+
+	replayer := har.NewReplayer(archives)
+	names := replayer.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("vhost replay %s (%d entries)", name, len(archives[name].Log.Entries))
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", o.address, o.port))
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening on %s", l.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Handler: replayer,
+	}
+	go server.Serve(l)
+	<-ctx.Done()
+	return server.Shutdown(context.Background())
+}
+
+func NewCommand() *cobra.Command {
+	o := NewKubeVhostReplayOptions()
+
+	cmd := &cobra.Command{
+		Use:   "replay DIR [--port=PORT]",
+		Short: "Serve responses recorded by `server --record` without a cluster",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.Run(cmd, args))
+		},
+	}
+
+	cmd.Flags().IntVarP(&o.port, "port", "p", o.port, "The port on which to serve. Set to 0 to pick a random port.")
+	cmd.Flags().StringVar(&o.address, "address", o.address, "The IP address on which to serve on.")
+	return cmd
+}
@@ -0,0 +1,73 @@
+package vhostserver
+
+import (
+	"sync"
+
+	"github.com/josudoey/kube"
+	"github.com/josudoey/kube/kubeutil"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+type kubeClient struct {
+	clientset  kubernetes.Interface
+	client     corev1client.CoreV1Interface
+	restConfig *rest.Config
+}
+
+// kubeClients creates one client per kubeconfig context and reuses it
+// across config reloads.
+type kubeClients struct {
+	f cmdutil.Factory
+
+	lock sync.Mutex
+	m    map[string]*kubeClient
+}
+
+func newKubeClients(f cmdutil.Factory) *kubeClients {
+	return &kubeClients{
+		f: f,
+		m: map[string]*kubeClient{},
+	}
+}
+
+func (c *kubeClients) factory(context string) cmdutil.Factory {
+	if context == "" {
+		return c.f
+	}
+	return kubeutil.ContextFactory(context)
+}
+
+func (c *kubeClients) get(context string) (*kubeClient, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if kc, ok := c.m[context]; ok {
+		return kc, nil
+	}
+
+	f := c.factory(context)
+	clientset, err := kube.GetClientset(f)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := f.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	kc := &kubeClient{
+		clientset:  clientset,
+		client:     clientset.CoreV1(),
+		restConfig: restConfig,
+	}
+	c.m[context] = kc
+	return kc, nil
+}
+
+// namespace returns the namespace of the kubeconfig context.
+func (c *kubeClients) namespace(context string) (string, error) {
+	namespace, _, err := c.factory(context).ToRawKubeConfigLoader().Namespace()
+	return namespace, err
+}
@@ -0,0 +1,378 @@
+package vhostserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/josudoey/kube/vhost"
+	"golang.org/x/net/http/httpguts"
+	"google.golang.org/grpc/codes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// Config describes the listeners, the watched namespaces and the routing
+// of the vhost server. It is read from a YAML or JSON file.
+type Config struct {
+	Listeners  []ListenerConfig         `json:"listeners,omitempty"`
+	Namespaces []NamespaceConfig        `json:"namespaces,omitempty"`
+	Aliases    map[string]string        `json:"aliases,omitempty"`
+	Routes     []RouteConfig            `json:"routes,omitempty"`
+	Services   map[string]ServiceConfig `json:"services,omitempty"`
+}
+
+// ListenerConfig is a TCP listener on Address and Port, a Unix socket at
+// Unix routed by Host, or a directory UnixDir holding one Unix socket per
+// vhost named <vhost>.sock, created and removed as services come and go.
+// Mode holds the octal permissions of Unix sockets, 0600 by default.
+type ListenerConfig struct {
+	Address string `json:"address,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Unix    string `json:"unix,omitempty"`
+	UnixDir string `json:"unixDir,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+func (l ListenerConfig) isUnix() bool {
+	return l.Unix != "" || l.UnixDir != ""
+}
+
+func (l ListenerConfig) HostPort() string {
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+// NamespaceConfig selects the services and pods served as vhosts. The
+// vhost names of the namespace start with HostPrefix and end with
+// HostSuffix. Context names the kubeconfig context, the current one when
+// empty. PodSelector and FieldSelector filter the pods only.
+type NamespaceConfig struct {
+	Context       string `json:"context,omitempty"`
+	Name          string `json:"name"`
+	Selector      string `json:"selector,omitempty"`
+	PodSelector   string `json:"podSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	HostPrefix    string `json:"hostPrefix,omitempty"`
+	HostSuffix    string `json:"hostSuffix,omitempty"`
+}
+
+// RouteConfig sends the requests for Host whose path starts with
+// PathPrefix to the vhost named Service.
+type RouteConfig struct {
+	Host       string `json:"host"`
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	Service    string `json:"service"`
+}
+
+// ServiceConfig holds the options of a vhost, keyed by any host name of
+// the vhost. Timeout bounds HTTP requests and gRPC calls. RequestHeaders
+// and HostRewrite apply to gRPC metadata and :authority as well, while
+// ResponseHeaders apply to HTTP only. Intercept sends the HTTP and gRPC
+// traffic of the vhost to a local host:port instead of its pods.
+type ServiceConfig struct {
+	LBPolicy        vhost.LBPolicy     `json:"lbPolicy,omitempty"`
+	Timeout         metav1.Duration    `json:"timeout,omitempty"`
+	HostRewrite     string             `json:"hostRewrite,omitempty"`
+	RequestHeaders  *vhost.HeaderRules `json:"requestHeaders,omitempty"`
+	ResponseHeaders *vhost.HeaderRules `json:"responseHeaders,omitempty"`
+	Faults          []vhost.Fault      `json:"faults,omitempty"`
+	Mirror          *MirrorConfig      `json:"mirror,omitempty"`
+	Limit           *vhost.Limit       `json:"limit,omitempty"`
+	PodLimit        *vhost.Limit       `json:"podLimit,omitempty"`
+	Intercept       string             `json:"intercept,omitempty"`
+}
+
+// LoadConfig reads the config file at path. Unknown fields are errors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Validate returns every problem of the config with its field path.
+func (c *Config) Validate() field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	listenersPath := field.NewPath("listeners")
+	listeners := map[string]bool{}
+	for i, l := range c.Listeners {
+		idxPath := listenersPath.Index(i)
+		if l.Unix != "" && l.UnixDir != "" {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("unixDir"), l.UnixDir, "must not be set with unix"))
+		}
+		if l.isUnix() {
+			if l.Port != 0 || l.Address != "" {
+				allErrs = append(allErrs, field.Invalid(idxPath, l.HostPort(), "address and port must not be set with a Unix socket"))
+			}
+			if l.Mode != "" {
+				if _, err := strconv.ParseUint(l.Mode, 8, 32); err != nil {
+					allErrs = append(allErrs, field.Invalid(idxPath.Child("mode"), l.Mode, "must be octal permissions, e.g. 0660"))
+				}
+			}
+			key := l.Unix + l.UnixDir
+			if listeners[key] {
+				allErrs = append(allErrs, field.Duplicate(idxPath, key))
+			}
+			listeners[key] = true
+			continue
+		}
+		if l.Mode != "" {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("mode"), l.Mode, "only applies to Unix sockets"))
+		}
+		if l.Port < 0 || l.Port > 65535 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("port"), l.Port, "must be between 0 and 65535"))
+			continue
+		}
+		if l.Port == 0 {
+			continue
+		}
+		if listeners[l.HostPort()] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, l.HostPort()))
+		}
+		listeners[l.HostPort()] = true
+	}
+
+	namespacesPath := field.NewPath("namespaces")
+	namespaces := map[string]bool{}
+	affixes := map[string]bool{}
+	for i, ns := range c.Namespaces {
+		idxPath := namespacesPath.Index(i)
+		if ns.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		}
+		for _, msg := range validation.IsDNS1123Label(ns.Name) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), ns.Name, msg))
+		}
+		if _, err := labels.Parse(ns.Selector); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("selector"), ns.Selector, err.Error()))
+		}
+		if _, err := labels.Parse(ns.PodSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("podSelector"), ns.PodSelector, err.Error()))
+		}
+		if _, err := fields.ParseSelector(ns.FieldSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("fieldSelector"), ns.FieldSelector, err.Error()))
+		}
+		key := sourceKey(ns)
+		if namespaces[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, key))
+		}
+		namespaces[key] = true
+		affix := ns.HostPrefix + "*" + ns.HostSuffix
+		if affixes[affix] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("hostSuffix"), affix))
+		}
+		affixes[affix] = true
+	}
+
+	aliasesPath := field.NewPath("aliases")
+	for alias, name := range c.Aliases {
+		if name == "" {
+			allErrs = append(allErrs, field.Required(aliasesPath.Key(alias), "must name a vhost"))
+		}
+		if name == alias {
+			allErrs = append(allErrs, field.Invalid(aliasesPath.Key(alias), name, "must not refer to itself"))
+		}
+	}
+
+	routesPath := field.NewPath("routes")
+	for i, r := range c.Routes {
+		idxPath := routesPath.Index(i)
+		if r.Host == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("host"), ""))
+		}
+		if r.Service == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("service"), ""))
+		}
+		if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("pathPrefix"), r.PathPrefix, "must start with '/'"))
+		}
+	}
+
+	servicesPath := field.NewPath("services")
+	for name, svc := range c.Services {
+		svcPath := servicesPath.Key(name)
+		if svc.LBPolicy != "" && !svc.LBPolicy.IsValid() {
+			supported := []string{}
+			for _, p := range vhost.LBPolicies {
+				supported = append(supported, string(p))
+			}
+			allErrs = append(allErrs, field.NotSupported(svcPath.Child("lbPolicy"), svc.LBPolicy, supported))
+		}
+		if svc.Timeout.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(svcPath.Child("timeout"), svc.Timeout.Duration.String(), "must not be negative"))
+		}
+		allErrs = append(allErrs, validateHeaderRules(svc.RequestHeaders, svcPath.Child("requestHeaders"))...)
+		allErrs = append(allErrs, validateHeaderRules(svc.ResponseHeaders, svcPath.Child("responseHeaders"))...)
+		if m := svc.Mirror; m != nil {
+			if m.Service == "" {
+				allErrs = append(allErrs, field.Required(svcPath.Child("mirror", "service"), ""))
+			}
+			if m.Service == name {
+				allErrs = append(allErrs, field.Invalid(svcPath.Child("mirror", "service"), m.Service, "must not mirror to itself"))
+			}
+			allErrs = append(allErrs, validatePercentage(m.Percentage, svcPath.Child("mirror", "percentage"))...)
+		}
+		allErrs = append(allErrs, validateLimit(svc.Limit, svcPath.Child("limit"))...)
+		allErrs = append(allErrs, validateLimit(svc.PodLimit, svcPath.Child("podLimit"))...)
+		if svc.Intercept != "" {
+			if _, port, err := net.SplitHostPort(svc.Intercept); err != nil || validation.IsValidPortNum(atoi(port)) != nil {
+				allErrs = append(allErrs, field.Invalid(svcPath.Child("intercept"), svc.Intercept, "must be a host:port, e.g. localhost:3000"))
+			}
+		}
+		for i, f := range svc.Faults {
+			allErrs = append(allErrs, validateFault(f, svcPath.Child("faults").Index(i))...)
+		}
+	}
+	return allErrs
+}
+
+// loadConfig reads the config file, if any, fills in the listener and
+// namespaces from the flags when the file leaves them out and validates
+// the result.
+func (o *KubeVhostServerOptions) loadConfig(namespaces []NamespaceConfig) (*Config, error) {
+	config := &Config{}
+	if o.configFile != "" {
+		c, err := LoadConfig(o.configFile)
+		if err != nil {
+			return nil, err
+		}
+		config = c
+	}
+
+	if len(config.Listeners) == 0 {
+		config.Listeners = []ListenerConfig{{
+			Address: o.address,
+			Port:    o.port,
+		}}
+		if o.unixSocket != "" {
+			config.Listeners = append(config.Listeners, ListenerConfig{Unix: o.unixSocket})
+		}
+		if o.unixSocketDir != "" {
+			config.Listeners = append(config.Listeners, ListenerConfig{UnixDir: o.unixSocketDir})
+		}
+	}
+	for i := range config.Listeners {
+		if config.Listeners[i].isUnix() {
+			continue
+		}
+		if config.Listeners[i].Address == "" {
+			config.Listeners[i].Address = defaultAddress
+		}
+	}
+	if len(config.Namespaces) == 0 {
+		config.Namespaces = namespaces
+	}
+	for name, target := range o.intercepts {
+		if config.Services == nil {
+			config.Services = map[string]ServiceConfig{}
+		}
+		svc := config.Services[name]
+		svc.Intercept = target
+		config.Services[name] = svc
+	}
+
+	if err := config.Validate().ToAggregate(); err != nil {
+		if o.configFile != "" {
+			return nil, fmt.Errorf("%s: %v", o.configFile, err)
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func validateHeaderRules(rules *vhost.HeaderRules, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if rules == nil {
+		return allErrs
+	}
+	for _, name := range rules.Names() {
+		if !httpguts.ValidHeaderFieldName(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath, name, "must be a valid header name"))
+		}
+	}
+	for name, value := range rules.Set {
+		if !httpguts.ValidHeaderFieldValue(value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("set").Key(name), value, "must be a valid header value"))
+		}
+	}
+	for name, value := range rules.Add {
+		if !httpguts.ValidHeaderFieldValue(value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("add").Key(name), value, "must be a valid header value"))
+		}
+	}
+	return allErrs
+}
+
+// validatePercentage accepts an unset percentage, which means all
+// requests.
+func validatePercentage(percentage *float64, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if percentage != nil && (*percentage < 0 || *percentage > 100) {
+		allErrs = append(allErrs, field.Invalid(fldPath, *percentage, "must be between 0 and 100"))
+	}
+	return allErrs
+}
+
+func validateFault(f vhost.Fault, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if f.PathPrefix != "" && !strings.HasPrefix(f.PathPrefix, "/") {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("pathPrefix"), f.PathPrefix, "must start with '/'"))
+	}
+	allErrs = append(allErrs, validatePercentage(f.Percentage, fldPath.Child("percentage"))...)
+	if f.Delay.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("delay"), f.Delay.Duration.String(), "must not be negative"))
+	}
+	if f.DelayJitter.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("delayJitter"), f.DelayJitter.Duration.String(), "must not be negative"))
+	}
+	if f.AbortStatus != 0 && (f.AbortStatus < 200 || f.AbortStatus > 599) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("abortStatus"), f.AbortStatus, "must be an HTTP status between 200 and 599"))
+	}
+	if f.AbortGRPCCode != nil && *f.AbortGRPCCode == codes.OK {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("abortGRPCCode"), f.AbortGRPCCode.String(), "must not be OK"))
+	}
+	if f.ResetAfterBytes < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("resetAfterBytes"), f.ResetAfterBytes, "must not be negative"))
+	}
+	if f.ResetAfter.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("resetAfter"), f.ResetAfter.Duration.String(), "must not be negative"))
+	}
+	return allErrs
+}
+
+func validateLimit(limit *vhost.Limit, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if limit == nil {
+		return allErrs
+	}
+	if limit.QPS < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("qps"), limit.QPS, "must not be negative"))
+	}
+	if limit.Burst < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("burst"), limit.Burst, "must not be negative"))
+	}
+	if limit.MaxConcurrent < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxConcurrent"), limit.MaxConcurrent, "must not be negative"))
+	}
+	return allErrs
+}
@@ -0,0 +1,187 @@
+package vhostserver
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		// fields are the paths of the expected errors
+		fields []string
+	}{
+		{
+			name: "valid",
+			config: `
+listeners:
+- port: 8010
+- unixDir: /tmp/vhost
+  mode: "0660"
+namespaces:
+- name: default
+  selector: app=web
+  fieldSelector: status.phase=Running
+- name: staging
+  context: staging
+  hostSuffix: .staging
+aliases:
+  web.local: web-80
+routes:
+- host: web.local
+  pathPrefix: /api/
+  service: api-8080
+services:
+  api-8080:
+    lbPolicy: round-robin
+    timeout: 30s
+    faults:
+    - percentage: 0
+      abortStatus: 503
+    mirror:
+      service: api-v2-8080
+    limit:
+      qps: 50
+      burst: 100
+    intercept: localhost:3000
+`,
+		},
+		{
+			name: "listeners",
+			config: `
+listeners:
+- port: 8010
+- port: 8010
+- port: 70000
+- unix: /tmp/a.sock
+  unixDir: /tmp/vhost
+- unix: /tmp/b.sock
+  port: 8011
+- unix: /tmp/c.sock
+  mode: rw
+- port: 8012
+  mode: "0600"
+`,
+			fields: []string{
+				"listeners[1]",
+				"listeners[2].port",
+				"listeners[3].unixDir",
+				"listeners[4]",
+				"listeners[5].mode",
+				"listeners[6].mode",
+			},
+		},
+		{
+			name: "namespaces",
+			config: `
+namespaces:
+- name: ""
+  hostSuffix: .a
+- name: Default
+  hostSuffix: .b
+- name: default
+  selector: "a b"
+  hostSuffix: .c
+- name: default
+  podSelector: "!="
+  fieldSelector: "a"
+  hostSuffix: .d
+- name: other
+  hostSuffix: .d
+- name: other
+  hostSuffix: .e
+`,
+			fields: []string{
+				"namespaces[0].name",
+				"namespaces[1].name",
+				"namespaces[2].selector",
+				"namespaces[3].fieldSelector",
+				"namespaces[3].podSelector",
+				"namespaces[4].hostSuffix",
+				"namespaces[5]",
+				// the empty name fails both required and the label check
+				"namespaces[0].name",
+			},
+		},
+		{
+			name: "aliases and routes",
+			config: `
+aliases:
+  a: a
+  b: ""
+routes:
+- pathPrefix: api
+`,
+			fields: []string{
+				"aliases[a]",
+				"aliases[b]",
+				"routes[0].host",
+				"routes[0].pathPrefix",
+				"routes[0].service",
+			},
+		},
+		{
+			name: "services",
+			config: `
+services:
+  api-8080:
+    lbPolicy: least-conn
+    timeout: -1s
+    requestHeaders:
+      set:
+        "bad header": x
+    faults:
+    - pathPrefix: slow
+      percentage: 101
+      delay: -1s
+      abortStatus: 99
+      abortGRPCCode: OK
+    mirror:
+      service: api-8080
+      percentage: -1
+    limit:
+      qps: -1
+    podLimit:
+      maxConcurrent: -1
+    intercept: localhost
+`,
+			fields: []string{
+				"services[api-8080].faults[0].abortGRPCCode",
+				"services[api-8080].faults[0].abortStatus",
+				"services[api-8080].faults[0].delay",
+				"services[api-8080].faults[0].pathPrefix",
+				"services[api-8080].faults[0].percentage",
+				"services[api-8080].intercept",
+				"services[api-8080].lbPolicy",
+				"services[api-8080].limit.qps",
+				"services[api-8080].mirror.percentage",
+				"services[api-8080].mirror.service",
+				"services[api-8080].podLimit.maxConcurrent",
+				"services[api-8080].requestHeaders",
+				"services[api-8080].timeout",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			if err := yaml.UnmarshalStrict([]byte(tt.config), config); err != nil {
+				t.Fatal(err)
+			}
+			fields := []string{}
+			for _, err := range config.Validate() {
+				fields = append(fields, err.Field)
+			}
+			want := append([]string{}, tt.fields...)
+			sort.Strings(fields)
+			sort.Strings(want)
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("got errors of %v, want %v\n%v", fields, want, config.Validate().ToAggregate())
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package vhostserver
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultSocketMode = 0600
+	socketExt         = ".sock"
+)
+
+// listenerSpec is a listener to open. Connections to a listener with a
+// host are routed to that vhost whatever their Host header says.
+type listenerSpec struct {
+	network string
+	address string
+	mode    os.FileMode
+	host    string
+}
+
+func (l listenerSpec) key() string {
+	return l.network + ":" + l.address
+}
+
+func (l listenerSpec) listen() (net.Listener, error) {
+	if l.network != "unix" {
+		return net.Listen(l.network, l.address)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.address), 0755); err != nil {
+		return nil, err
+	}
+	// remove a socket left behind by a previous run
+	if info, err := os.Lstat(l.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(l.address)
+	}
+	listener, err := net.Listen("unix", l.address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(l.address, l.mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (l ListenerConfig) socketMode() os.FileMode {
+	if l.Mode == "" {
+		return defaultSocketMode
+	}
+	mode, _ := strconv.ParseUint(l.Mode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// listenerSpecs returns the listeners of config. Each UnixDir listener
+// gets one socket per vhost of router.
+func listenerSpecs(config *Config, router *vhostRouter) []listenerSpec {
+	specs := []listenerSpec{}
+	for _, lc := range config.Listeners {
+		switch {
+		case lc.Unix != "":
+			specs = append(specs, listenerSpec{
+				network: "unix",
+				address: lc.Unix,
+				mode:    lc.socketMode(),
+			})
+		case lc.UnixDir != "":
+			names := []string{}
+			for name := range router.vhosts() {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				specs = append(specs, listenerSpec{
+					network: "unix",
+					address: filepath.Join(lc.UnixDir, name+socketExt),
+					mode:    lc.socketMode(),
+					host:    name,
+				})
+			}
+		default:
+			specs = append(specs, listenerSpec{
+				network: "tcp",
+				address: lc.HostPort(),
+			})
+		}
+	}
+	return specs
+}
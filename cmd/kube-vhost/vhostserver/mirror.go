@@ -0,0 +1,131 @@
+package vhostserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMirrorMaxBodySize is the largest request body that is
+	// buffered to be mirrored. Larger requests are not mirrored.
+	defaultMirrorMaxBodySize = 1 << 20
+	// defaultMirrorTimeout bounds the shadow request and the wait for the
+	// primary response to compare with.
+	defaultMirrorTimeout = 30 * time.Second
+)
+
+// MirrorConfig duplicates a share of the HTTP requests of a vhost to the
+// vhost named Service. Percentage is the share of requests mirrored, all
+// of them when unset.
+type MirrorConfig struct {
+	Service    string   `json:"service"`
+	Percentage *float64 `json:"percentage,omitempty"`
+}
+
+func (m *MirrorConfig) hit() bool {
+	if m.Percentage == nil || *m.Percentage >= 100 {
+		return true
+	}
+	return rand.Float64()*100 < *m.Percentage
+}
+
+type mirrorResult struct {
+	status  int
+	latency time.Duration
+	err     error
+}
+
+func (r mirrorResult) String() string {
+	if r.err != nil {
+		return r.err.Error()
+	}
+	return http.StatusText(r.status)
+}
+
+// mirror sends a copy of req to the shadow vhost in the background and
+// returns the channel on which the primary result is expected, or nil if
+// the request is not mirrored. The shadow response is discarded and only
+// compared with the primary one in the log.
+func (r *vhostRouter) mirror(req *http.Request, name string, mirror *MirrorConfig) chan<- mirrorResult {
+	if !mirror.hit() {
+		return nil
+	}
+	src, shadowName, _ := r.resolve(mirror.Service, req.URL.Path)
+	if src == nil {
+		log.Printf("Mirror %s -> %s: vhost not found", name, mirror.Service)
+		return nil
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		buf, err := ioutil.ReadAll(io.LimitReader(req.Body, defaultMirrorMaxBodySize+1))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		if err != nil || len(buf) > defaultMirrorMaxBodySize {
+			return nil
+		}
+		body = buf
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMirrorTimeout)
+	shadow := req.Clone(ctx)
+	shadow.RequestURI = ""
+	shadow.URL.Scheme = "http"
+	shadow.URL.Host = shadowName
+	shadow.Body = ioutil.NopCloser(bytes.NewReader(body))
+	shadow.ContentLength = int64(len(body))
+
+	primary := make(chan mirrorResult, 1)
+	go func() {
+		defer cancel()
+		start := time.Now()
+		result := mirrorResult{}
+		res, err := src.transport.RoundTrip(shadow)
+		if err != nil {
+			result.err = err
+		} else {
+			result.status = res.StatusCode
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		result.latency = time.Since(start)
+
+		select {
+		case p := <-primary:
+			diff := ""
+			if p.status != result.status || p.err != nil || result.err != nil {
+				diff = " (status differs)"
+			}
+			log.Printf("Mirror %s %s: %s -> %s %v, %s -> %s %v%s",
+				req.Method, req.URL.RequestURI(),
+				name, p, p.latency,
+				mirror.Service, result, result.latency,
+				diff)
+		case <-ctx.Done():
+			log.Printf("Mirror %s %s: %s -> no response, %s -> %s %v",
+				req.Method, req.URL.RequestURI(),
+				name,
+				mirror.Service, result, result.latency)
+		}
+	}()
+	return primary
+}
+
+// reportPrimary hands the primary result to a pending mirror, if any.
+func reportPrimary(primary chan<- mirrorResult, result mirrorResult) {
+	if primary == nil {
+		return
+	}
+	select {
+	case primary <- result:
+	default:
+	}
+}
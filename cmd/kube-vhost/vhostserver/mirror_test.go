@@ -0,0 +1,106 @@
+package vhostserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josudoey/kube/kubetest"
+	"github.com/josudoey/kube/vhost"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// shadowRequest is a mirrored request as the shadow vhost got it.
+type shadowRequest struct {
+	host string
+	body string
+}
+
+// newMirrorRouter returns the router of the api and api-v2 services whose
+// requests are passed to shadows.
+func newMirrorRouter(t *testing.T, shadows chan<- shadowRequest) *vhostRouter {
+	t.Helper()
+	resolver := vhost.NewPortForwardResolver()
+	for _, name := range []string{"api", "api-v2"} {
+		resolver.AddService(*kubetest.NewService(name,
+			kubetest.WithServiceSelector(map[string]string{"app": name}),
+			kubetest.WithServicePort("http", 8080, 8080),
+		))
+	}
+	ns := NamespaceConfig{Name: metav1.NamespaceDefault}
+	config := &Config{Namespaces: []NamespaceConfig{ns}}
+	sources := map[string]*source{
+		sourceKey(ns): {
+			namespace: ns.Name,
+			resolver:  resolver,
+			transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				body, _ := ioutil.ReadAll(req.Body)
+				shadows <- shadowRequest{host: req.URL.Host, body: string(body)}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+		},
+	}
+	r, err := newVhostRouter(config, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestMirror(t *testing.T) {
+	shadows := make(chan shadowRequest, 1)
+	r := newMirrorRouter(t, shadows)
+	req := httptest.NewRequest(http.MethodPost, "http://api-8080/items", strings.NewReader("item"))
+
+	primary := r.mirror(req, "api-8080", &MirrorConfig{Service: "api-v2-8080"})
+	if primary == nil {
+		t.Fatal("request not mirrored")
+	}
+	defer reportPrimary(primary, mirrorResult{status: http.StatusOK})
+	select {
+	case shadow := <-shadows:
+		if shadow.host != "api-v2-8080" || shadow.body != "item" {
+			t.Errorf("got shadow %+v, want the body sent to api-v2-8080", shadow)
+		}
+	case <-time.After(kubetest.WatchTimeout):
+		t.Fatal("shadow vhost got no request")
+	}
+	// the primary request still has its whole body
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "item" {
+		t.Errorf("got primary body %q, want item", body)
+	}
+}
+
+func TestMirrorSkipped(t *testing.T) {
+	none := 0.0
+	tests := []struct {
+		name   string
+		body   string
+		mirror *MirrorConfig
+	}{
+		{name: "percentage", body: "item", mirror: &MirrorConfig{Service: "api-v2-8080", Percentage: &none}},
+		{name: "unknown vhost", body: "item", mirror: &MirrorConfig{Service: "web-80"}},
+		{name: "large body", body: strings.Repeat("x", defaultMirrorMaxBodySize+1), mirror: &MirrorConfig{Service: "api-v2-8080"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newMirrorRouter(t, make(chan shadowRequest, 1))
+			req := httptest.NewRequest(http.MethodPost, "http://api-8080/items", strings.NewReader(tt.body))
+			if primary := r.mirror(req, "api-8080", tt.mirror); primary != nil {
+				t.Error("request mirrored")
+			}
+			if body, _ := ioutil.ReadAll(req.Body); string(body) != tt.body {
+				t.Errorf("got %d bytes of the primary body, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
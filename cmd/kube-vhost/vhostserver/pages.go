@@ -0,0 +1,112 @@
+package vhostserver
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// vhostStatus describes a vhost on the index and error pages.
+type vhostStatus struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Port      int32  `json:"port"`
+	ReadyPods int    `json:"readyPods"`
+}
+
+type page struct {
+	Status  int           `json:"status,omitempty"`
+	Message string        `json:"message,omitempty"`
+	Vhosts  []vhostStatus `json:"vhosts"`
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>kube-vhost</title></head>
+<body>
+{{if .Status}}<h1>{{.Status}} {{.Message}}</h1>{{else}}<h1>kube-vhost</h1>{{end}}
+<table>
+<tr><th>vhost</th><th>namespace</th><th>service</th><th>port</th><th>ready pods</th></tr>
+{{range .Vhosts}}<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td>{{.Namespace}}</td><td>{{.Service}}</td><td>{{.Port}}</td><td>{{.ReadyPods}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// isServerHost reports whether host addresses the server itself rather
+// than a vhost.
+func isServerHost(host string) bool {
+	return host == "" || host == "localhost" || net.ParseIP(host) != nil
+}
+
+// vhostStatuses returns every vhost with its ready pod count, linked on
+// the port of reqHost.
+func (r *vhostRouter) vhostStatuses(reqHost string) []vhostStatus {
+	_, port, _ := net.SplitHostPort(reqHost)
+	statuses := []vhostStatus{}
+	for _, src := range r.sources {
+		for _, svc := range src.resolver.ListServices() {
+			ready := src.resolver.ReadyPods(svc.SourceHostPort())
+			for _, name := range src.resolver.ListHostNames(svc) {
+				name = src.hostPrefix + name + src.hostSuffix
+				u := "http://" + name + "/"
+				if port != "" {
+					u = "http://" + net.JoinHostPort(name, port) + "/"
+				}
+				statuses = append(statuses, vhostStatus{
+					Name:      name,
+					URL:       u,
+					Namespace: svc.Service.GetNamespace(),
+					Service:   svc.Service.GetName(),
+					Port:      svc.ServicePort.Port,
+					ReadyPods: ready,
+				})
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// wantsJSON reports whether the client prefers JSON over HTML.
+func wantsJSON(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// writePage writes the vhost list with status and message as HTML or
+// JSON, depending on the Accept header. A zero status writes the index.
+func (r *vhostRouter) writePage(rw http.ResponseWriter, req *http.Request, status int, message string) {
+	p := page{
+		Status:  status,
+		Message: message,
+		Vhosts:  r.vhostStatuses(req.Host),
+	}
+	code := status
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	if wantsJSON(req) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(code)
+		if err := json.NewEncoder(rw).Encode(p); err != nil {
+			log.Printf("http: write page: %v", err)
+		}
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(code)
+	if err := pageTemplate.Execute(rw, p); err != nil {
+		log.Printf("http: write page: %v", err)
+	}
+}
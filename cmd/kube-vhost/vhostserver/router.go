@@ -0,0 +1,331 @@
+package vhostserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/josudoey/kube/vhost"
+	"github.com/josudoey/kube/vhost/har"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+type routeSource struct {
+	*source
+	hostPrefix string
+	hostSuffix string
+}
+
+// vhostRouter maps request hosts to the vhosts of the sources according
+// to a config. It is rebuilt whenever the config is reloaded.
+type vhostRouter struct {
+	config   *Config
+	sources  []routeSource
+	aliases  map[string]string
+	recorder *har.Recorder
+	// services and limiters are keyed by the canonical full vhost name
+	services map[string]routedService
+	limiters map[string]*vhost.Limiter
+}
+
+// routedService is the config of a vhost with the source serving it.
+type routedService struct {
+	ServiceConfig
+	src *source
+	// name is the source host name of the vhost inside src
+	name string
+}
+
+// newVhostRouter returns the router of config. The keys of the services
+// of config may be any name of a vhost and must resolve to one. The
+// limiters of previous are carried over, so that a reload keeps the
+// requests in flight counted.
+func newVhostRouter(config *Config, sources map[string]*source, previous *vhostRouter) (*vhostRouter, error) {
+	r := &vhostRouter{
+		config:   config,
+		aliases:  map[string]string{},
+		services: map[string]routedService{},
+		limiters: map[string]*vhost.Limiter{},
+	}
+	for alias, name := range config.Aliases {
+		r.aliases[strings.ToLower(alias)] = strings.ToLower(name)
+	}
+	for _, ns := range config.Namespaces {
+		r.sources = append(r.sources, routeSource{
+			source:     sources[sourceKey(ns)],
+			hostPrefix: ns.HostPrefix,
+			hostSuffix: ns.HostSuffix,
+		})
+	}
+
+	keys := map[string]string{}
+	for key, svc := range config.Services {
+		src, entry, canonical := r.lookup(key)
+		if src == nil {
+			return nil, fmt.Errorf("services[%s]: vhost not found", key)
+		}
+		if other, ok := keys[canonical]; ok {
+			return nil, fmt.Errorf("services[%s]: vhost %s is already configured by services[%s]", key, canonical, other)
+		}
+		keys[canonical] = key
+		r.services[canonical] = routedService{
+			ServiceConfig: svc,
+			src:           src.source,
+			name:          entry.SourceHostName(),
+		}
+
+		var limiter *vhost.Limiter
+		if previous != nil {
+			limiter = previous.limiters[canonical]
+		}
+		if limiter != nil {
+			limiter.SetLimit(svc.Limit)
+		} else {
+			limiter = vhost.NewLimiter(svc.Limit)
+		}
+		r.limiters[canonical] = limiter
+	}
+	return r, nil
+}
+
+// trimHost returns host without prefix and suffix, or false when host
+// does not have both.
+func trimHost(host string, prefix string, suffix string) (string, bool) {
+	if len(host) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	if !strings.HasPrefix(host, prefix) || !strings.HasSuffix(host, suffix) {
+		return "", false
+	}
+	return host[len(prefix) : len(host)-len(suffix)], true
+}
+
+func hostOnly(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	return strings.ToLower(host)
+}
+
+// resolve applies the routes to host and returns the source serving it,
+// the vhost name inside the source and the canonical full vhost name,
+// under which the options of the vhost are kept. When no source serves
+// host, the last is the host name after routing.
+func (r *vhostRouter) resolve(host string, path string) (*source, string, string) {
+	host = strings.ToLower(host)
+	for _, route := range r.config.Routes {
+		if strings.ToLower(route.Host) == host && strings.HasPrefix(path, route.PathPrefix) {
+			host = strings.ToLower(route.Service)
+			break
+		}
+	}
+	src, entry, canonical := r.lookup(host)
+	if src == nil {
+		return nil, "", canonical
+	}
+	return src.source, entry.SourceHostName(), canonical
+}
+
+// lookup applies the aliases to host and returns the source serving it,
+// the entry of the vhost and its canonical full name: the source host
+// name of the entry with the affixes of the source. When no source serves
+// host, the name is host after the aliases.
+func (r *vhostRouter) lookup(host string) (*routeSource, *vhost.ServicePortEntry, string) {
+	host = strings.ToLower(host)
+	if name, ok := r.aliases[host]; ok {
+		host = name
+	}
+	for i := range r.sources {
+		src := &r.sources[i]
+		name, ok := trimHost(host, src.hostPrefix, src.hostSuffix)
+		if !ok {
+			continue
+		}
+		entry := src.resolver.ResolveService(name)
+		if entry == nil {
+			continue
+		}
+		return src, entry, src.hostPrefix + entry.SourceHostName() + src.hostSuffix
+	}
+	return nil, nil, host
+}
+
+// vhosts returns the full vhost name of every service port with its entry.
+func (r *vhostRouter) vhosts() map[string]vhost.ServicePortEntry {
+	names := map[string]vhost.ServicePortEntry{}
+	for _, src := range r.sources {
+		for _, svc := range src.resolver.ListServices() {
+			for _, name := range src.resolver.ListHostNames(svc) {
+				names[src.hostPrefix+name+src.hostSuffix] = svc
+			}
+		}
+	}
+	return names
+}
+
+func (r *vhostRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	src, name, fullName := r.resolve(hostOnly(req.Host), req.URL.Path)
+	if src == nil {
+		if isServerHost(hostOnly(req.Host)) && req.URL.Path == "/" {
+			r.writePage(rw, req, 0, "")
+			return
+		}
+		r.writePage(rw, req, http.StatusNotFound, fmt.Sprintf("vhost %s not found", fullName))
+		return
+	}
+
+	svc := r.services[fullName]
+	if svc.Timeout.Duration > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), svc.Timeout.Duration)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	fault := vhost.SelectFault(svc.Faults, req.URL.Path)
+	if fault != nil {
+		if !sleep(req.Context(), fault.DelayDuration()) {
+			return
+		}
+		if fault.AbortStatus != 0 {
+			http.Error(rw, "fault injected", fault.AbortStatus)
+			return
+		}
+	}
+
+	release, err := r.limiters[fullName].Acquire()
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%s: %v", fullName, err), http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	var primary chan<- mirrorResult
+	if svc.Mirror != nil {
+		primary = r.mirror(req, fullName, svc.Mirror)
+	}
+	start := time.Now()
+
+	rp := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			out.URL.Scheme = "http"
+			out.URL.Host = name
+			if svc.HostRewrite != "" {
+				out.Host = svc.HostRewrite
+			}
+			svc.RequestHeaders.Apply(out.Header)
+		},
+		ModifyResponse: func(res *http.Response) error {
+			reportPrimary(primary, mirrorResult{status: res.StatusCode, latency: time.Since(start)})
+			svc.ResponseHeaders.Apply(res.Header)
+			if fault != nil && fault.Resets() {
+				res.Body = vhost.NewResetBody(res.Body, fault.ResetAfterBytes, fault.ResetAfter.Duration)
+			}
+			return nil
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			reportPrimary(primary, mirrorResult{err: err, latency: time.Since(start)})
+			if errors.Is(err, vhost.ErrLimitExceeded) {
+				http.Error(rw, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			log.Printf("http: proxy error: %v", err)
+			r.writePage(rw, req, http.StatusBadGateway, fmt.Sprintf("%s: %v", fullName, err))
+		},
+		Transport: src.transport,
+	}
+	if r.recorder != nil {
+		rp.Transport = r.recorder.Transport(fullName, src.transport)
+	}
+	rp.ServeHTTP(rw, req)
+}
+
+// sleep waits for d and reports false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// handleGRPC forwards a gRPC connection to the vhost of authority. A
+// fault delay ends early when ctx is done.
+func (r *vhostRouter) handleGRPC(ctx context.Context, local net.Conn, preface *vhost.GRPCPreface, authority string) (err error) {
+	src, name, fullName := r.resolve(hostOnly(authority), preface.Path())
+	if src == nil {
+		err := fmt.Errorf("%s svc not found", fullName)
+		runtime.HandleError(err)
+		return err
+	}
+
+	svc := r.services[fullName]
+	var reset net.Conn
+	defer func() {
+		// the caller closes its own conn, which would leave the reset
+		// timer running
+		if err != nil && reset != nil {
+			reset.Close()
+		}
+	}()
+	if fault := vhost.SelectFault(svc.Faults, preface.Path()); fault != nil {
+		if !sleep(ctx, fault.DelayDuration()) {
+			return ctx.Err()
+		}
+		if fault.AbortGRPCCode != nil {
+			vhost.WriteGRPCStatus(local, preface, *fault.AbortGRPCCode, "fault injected")
+			return fmt.Errorf("%s fault injected", fullName)
+		}
+		if fault.Resets() {
+			local = vhost.NewResetConn(local, fault.ResetAfterBytes, fault.ResetAfter.Duration)
+			reset = local
+		}
+	}
+	release, err := r.limiters[fullName].Acquire()
+	if err != nil {
+		vhost.WriteGRPCStatus(local, preface, codes.ResourceExhausted, fmt.Sprintf("%s: %v", fullName, err))
+		return err
+	}
+	local = &releaseConn{Conn: local, release: release}
+	if svc.HostRewrite != "" || !svc.RequestHeaders.IsEmpty() || svc.Timeout.Duration > 0 {
+		local, preface = vhost.NewGRPCHeaderRewriteConn(local, preface, func(fields []hpack.HeaderField) []hpack.HeaderField {
+			if svc.HostRewrite != "" {
+				fields = vhost.SetAuthority(fields, svc.HostRewrite)
+			}
+			// the server ends each call at its deadline
+			fields = vhost.SetGRPCTimeout(fields, svc.Timeout.Duration)
+			return svc.RequestHeaders.ApplyHPACK(fields)
+		})
+	}
+	err = src.resolver.ForwardGRPC(local, preface, name, src.client, src.restConfig, src.namespace)
+	if err != nil {
+		// the caller closes its own conn, not the wrapped one
+		release()
+	}
+	return err
+}
+
+// releaseConn releases a limiter slot when the connection is closed.
+type releaseConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *releaseConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,183 @@
+package vhostserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/josudoey/kube/kubetest"
+	"github.com/josudoey/kube/vhost"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc/codes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestRouter returns the router of a namespace serving the api service
+// without pods, configured by svc.
+func newTestRouter(t *testing.T, svc ServiceConfig) *vhostRouter {
+	t.Helper()
+	resolver := vhost.NewPortForwardResolver()
+	resolver.AddService(*kubetest.NewService("api",
+		kubetest.WithServiceSelector(map[string]string{"app": "api"}),
+		kubetest.WithServicePort("grpc", 8080, 8080),
+	))
+	ns := NamespaceConfig{Name: metav1.NamespaceDefault}
+	config := &Config{
+		Namespaces: []NamespaceConfig{ns},
+		Services:   map[string]ServiceConfig{"api-grpc": svc},
+	}
+	sources := map[string]*source{
+		sourceKey(ns): {namespace: ns.Name, resolver: resolver},
+	}
+	r, err := newVhostRouter(config, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func newTestPreface() *vhost.GRPCPreface {
+	return &vhost.GRPCPreface{
+		StreamID: 1,
+		Header: []hpack.HeaderField{
+			{Name: ":authority", Value: "api-8080"},
+			{Name: ":path", Value: "/api.Service/Call"},
+		},
+	}
+}
+
+// readGRPCStatus returns the grpc-status of the response written to conn.
+func readGRPCStatus(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	framer := http2.NewFramer(nil, conn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, ok := frame.(*http2.MetaHeadersFrame)
+	if !ok {
+		t.Fatalf("got %T, want headers", frame)
+	}
+	// the client is sent away after the status
+	if _, err := framer.ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+	return headers.PseudoValue("status") + " " + fieldValue(headers.Fields, "grpc-status")
+}
+
+func fieldValue(fields []hpack.HeaderField, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func TestHandleGRPCReleasesLimitOnError(t *testing.T) {
+	r := newTestRouter(t, ServiceConfig{Limit: &vhost.Limit{MaxConcurrent: 1}})
+
+	for i := 0; i < 2; i++ {
+		local, remote := net.Pipe()
+		// without pods the forward fails after the limiter let it pass
+		err := r.handleGRPC(context.Background(), local, newTestPreface(), "api-8080")
+		if err == nil {
+			t.Fatal("got no error without pods")
+		}
+		local.Close()
+		remote.Close()
+	}
+
+	release, err := r.limiters["api-8080"].Acquire()
+	if err != nil {
+		t.Fatalf("limiter still held after failed forwards: %v", err)
+	}
+	release()
+}
+
+func TestHandleGRPCLimitExceeded(t *testing.T) {
+	r := newTestRouter(t, ServiceConfig{Limit: &vhost.Limit{MaxConcurrent: 1}})
+	release, err := r.limiters["api-8080"].Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.handleGRPC(context.Background(), local, newTestPreface(), "api-8080")
+	}()
+
+	// 8 is RESOURCE_EXHAUSTED
+	if status := readGRPCStatus(t, remote); status != "200 8" {
+		t.Errorf("got status %q, want 200 8", status)
+	}
+	if err := <-done; !errors.Is(err, vhost.ErrLimitExceeded) {
+		t.Errorf("got %v, want %v", err, vhost.ErrLimitExceeded)
+	}
+}
+
+func TestHandleGRPCFaultDelayEndsWithContext(t *testing.T) {
+	r := newTestRouter(t, ServiceConfig{
+		Faults: []vhost.Fault{{Delay: metav1.Duration{Duration: time.Hour}}},
+	})
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.handleGRPC(ctx, local, newTestPreface(), "api-8080")
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(kubetest.WatchTimeout):
+		t.Fatal("fault delay did not end with the context")
+	}
+}
+
+func TestServeHTTPFaultAbort(t *testing.T) {
+	r := newTestRouter(t, ServiceConfig{
+		Faults: []vhost.Fault{{PathPrefix: "/api.Service/", AbortStatus: http.StatusServiceUnavailable}},
+	})
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://api-8080/api.Service/Call", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", rw.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandleGRPCFaultAbort(t *testing.T) {
+	code := codes.Unavailable
+	r := newTestRouter(t, ServiceConfig{
+		Faults: []vhost.Fault{{AbortGRPCCode: &code}},
+	})
+	local, remote := net.Pipe()
+	defer remote.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.handleGRPC(context.Background(), local, newTestPreface(), "api-8080")
+	}()
+
+	// 14 is UNAVAILABLE
+	if status := readGRPCStatus(t, remote); status != "200 14" {
+		t.Errorf("got status %q, want 200 14", status)
+	}
+	if err := <-done; err == nil {
+		t.Error("got no error for an aborted connection")
+	}
+}
@@ -0,0 +1,301 @@
+package vhostserver
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/josudoey/kube/vhost"
+	"github.com/josudoey/kube/vhost/har"
+)
+
+// vhostServer owns the listeners and sources of the running server and
+// applies config changes to them without dropping open connections.
+type vhostServer struct {
+	o       *KubeVhostServerOptions
+	clients *kubeClients
+
+	router   atomic.Value
+	server   *http.Server
+	recorder *har.Recorder
+
+	// ctx is cancelled on shutdown, to end the fault delays of hijacked
+	// gRPC connections that Shutdown does not track.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock      sync.Mutex
+	config    *Config
+	listeners map[string]net.Listener
+	sources   map[string]*source
+
+	// socketHosts maps the path of a per vhost Unix socket to its vhost.
+	socketHosts sync.Map
+	// socketSync asks for the per vhost Unix sockets to follow the
+	// services added and removed since.
+	socketSync chan struct{}
+}
+
+func (o *KubeVhostServerOptions) newVhostServer(clients *kubeClients) *vhostServer {
+	s := &vhostServer{
+		o:          o,
+		clients:    clients,
+		listeners:  map[string]net.Listener{},
+		sources:    map[string]*source{},
+		socketSync: make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.server = &http.Server{
+		Handler: vhost.NewGRPCHandler(http.HandlerFunc(s.serveHTTP), s.handleGRPC),
+	}
+	go func() {
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-s.socketSync:
+				s.syncSockets()
+			}
+		}
+	}()
+	return s
+}
+
+// servicesChanged schedules syncSockets without waiting for it, as it is
+// called by the resolvers while apply may hold the lock.
+func (s *vhostServer) servicesChanged() {
+	select {
+	case s.socketSync <- struct{}{}:
+	default:
+	}
+}
+
+// syncSockets opens the per vhost Unix sockets of vhosts added since the
+// config was applied and closes those of vhosts removed since.
+func (s *vhostServer) syncSockets() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.config == nil {
+		return
+	}
+
+	sockets := map[string]bool{}
+	for _, spec := range listenerSpecs(s.config, s.currentRouter()) {
+		if spec.host == "" {
+			continue
+		}
+		key := spec.key()
+		sockets[key] = true
+		if _, ok := s.listeners[key]; ok {
+			continue
+		}
+		l, err := spec.listen()
+		if err != nil {
+			log.Printf("Failed to listen on %s: %v", spec.address, err)
+			continue
+		}
+		s.socketHosts.Store(spec.address, spec.host)
+		s.listeners[key] = l
+		log.Printf("Listening on %s", l.Addr())
+		go s.server.Serve(l)
+	}
+	for key, l := range s.listeners {
+		if _, ok := s.socketHost(l.Addr()); !ok || sockets[key] {
+			continue
+		}
+		log.Printf("Stop listening on %s", l.Addr())
+		s.socketHosts.Delete(l.Addr().String())
+		l.Close()
+		delete(s.listeners, key)
+	}
+}
+
+func (s *vhostServer) currentRouter() *vhostRouter {
+	r, _ := s.router.Load().(*vhostRouter)
+	return r
+}
+
+// socketHost returns the vhost of the per vhost Unix socket addr.
+func (s *vhostServer) socketHost(addr net.Addr) (string, bool) {
+	if addr == nil || addr.Network() != "unix" {
+		return "", false
+	}
+	v, ok := s.socketHosts.Load(addr.String())
+	if !ok {
+		return "", false
+	}
+	host, _ := v.(string)
+	return host, true
+}
+
+func (s *vhostServer) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	addr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if host, ok := s.socketHost(addr); ok {
+		req.Host = host
+	}
+	s.currentRouter().ServeHTTP(rw, req)
+}
+
+func (s *vhostServer) handleGRPC(local net.Conn, preface *vhost.GRPCPreface) error {
+	host := preface.Authority()
+	if socketHost, ok := s.socketHost(local.LocalAddr()); ok {
+		host = socketHost
+	}
+	return s.currentRouter().handleGRPC(s.ctx, local, preface, host)
+}
+
+// apply starts the sources and listeners that config adds, switches
+// routing to config and then stops what config no longer has. On error
+// nothing is changed.
+func (s *vhostServer) apply(ctx context.Context, config *Config) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sources := map[string]*source{}
+	started := []*source{}
+	for _, ns := range config.Namespaces {
+		key := sourceKey(ns)
+		src, ok := s.sources[key]
+		if ok {
+			sources[key] = src
+			continue
+		}
+		kc, err := s.clients.get(ns.Context)
+		if err == nil {
+			src, err = s.o.startSource(ctx, kc, ns, s.servicesChanged)
+		}
+		if err != nil {
+			for _, src := range started {
+				go src.stop(s.o.shutdownTimeout)
+			}
+			return err
+		}
+		sources[key] = src
+		started = append(started, src)
+	}
+
+	router, err := newVhostRouter(config, sources, s.currentRouter())
+	if err != nil {
+		for _, src := range started {
+			go src.stop(s.o.shutdownTimeout)
+		}
+		return err
+	}
+	router.recorder = s.recorder
+
+	for _, src := range sources {
+		policies := map[string]vhost.LBPolicy{}
+		podLimits := map[string]*vhost.Limit{}
+		intercepts := map[string]string{}
+		for _, svc := range router.services {
+			if svc.src != src {
+				continue
+			}
+			if svc.LBPolicy != "" {
+				policies[svc.name] = svc.LBPolicy
+			}
+			if !svc.PodLimit.IsEmpty() {
+				podLimits[svc.name] = svc.PodLimit
+			}
+			if svc.Intercept != "" {
+				intercepts[svc.name] = svc.Intercept
+			}
+		}
+		src.resolver.SetLBPolicies(policies)
+		src.resolver.SetPodLimits(podLimits)
+		// the router has resolved the names, a service may have gone since
+		if err := src.resolver.SetIntercepts(intercepts); err != nil {
+			log.Printf("Intercept: %v", err)
+		}
+	}
+
+	listeners := map[string]net.Listener{}
+	opened := []net.Listener{}
+	for _, spec := range listenerSpecs(config, router) {
+		key := spec.key()
+		if spec.host != "" {
+			s.socketHosts.Store(spec.address, spec.host)
+		}
+		if l, ok := s.listeners[key]; ok {
+			listeners[key] = l
+			continue
+		}
+		l, err := spec.listen()
+		if err != nil {
+			for _, l := range opened {
+				l.Close()
+			}
+			for _, src := range started {
+				go src.stop(s.o.shutdownTimeout)
+			}
+			return err
+		}
+		listeners[key] = l
+		opened = append(opened, l)
+	}
+
+	s.router.Store(router)
+	for _, l := range opened {
+		log.Printf("Listening on %s", l.Addr())
+		go s.server.Serve(l)
+	}
+	for key, l := range s.listeners {
+		if _, ok := listeners[key]; ok {
+			continue
+		}
+		log.Printf("Stop listening on %s", l.Addr())
+		s.socketHosts.Delete(l.Addr().String())
+		l.Close()
+	}
+	for key, src := range s.sources {
+		if _, ok := sources[key]; ok {
+			continue
+		}
+		log.Printf("Stop serving %s", src)
+		go src.stop(s.o.shutdownTimeout)
+	}
+	s.config = config
+	s.listeners = listeners
+	s.sources = sources
+
+	for name, svc := range router.vhosts() {
+		log.Printf("vhost port-forward %s -> svc/%s", name, svc.SourceHostPort())
+	}
+	return nil
+}
+
+func (s *vhostServer) resolvers() []*vhost.PortForwardResolver {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	resolvers := []*vhost.PortForwardResolver{}
+	for _, src := range s.sources {
+		resolvers = append(resolvers, src.resolver)
+	}
+	return resolvers
+}
+
+// shutdown stops accepting connections and drains the active streams of
+// every source until ctx is done.
+func (s *vhostServer) shutdown(ctx context.Context) {
+	s.cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, src := range s.sources {
+		src.cancel()
+		if err := src.resolver.Shutdown(ctx); err != nil {
+			log.Printf("Closed port-forward connections before draining: %v", err)
+		}
+	}
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			log.Printf("Failed to write recordings: %v", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package vhostserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+	"github.com/josudoey/kube/vhost"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncSocketsFollowsServices(t *testing.T) {
+	dir := t.TempDir()
+	resolver := vhost.NewPortForwardResolver()
+	ns := NamespaceConfig{Name: metav1.NamespaceDefault}
+	s := (&KubeVhostServerOptions{}).newVhostServer(nil)
+	defer s.shutdown(context.Background())
+	s.sources[sourceKey(ns)] = &source{namespace: ns.Name, resolver: resolver, cancel: func() {}}
+
+	config := &Config{
+		Listeners:  []ListenerConfig{{UnixDir: dir}},
+		Namespaces: []NamespaceConfig{ns},
+	}
+	if err := s.apply(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "web-80"+socketExt)
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("got %v for the socket of a missing service", err)
+	}
+
+	svc := *kubetest.NewService("web",
+		kubetest.WithServiceSelector(map[string]string{"app": "web"}),
+		kubetest.WithServicePort("", 80, 8080),
+	)
+	resolver.AddService(svc)
+	s.syncSockets()
+	if host, ok := s.socketHost(&net.UnixAddr{Name: socket, Net: "unix"}); !ok || host != "web-80" {
+		t.Errorf("got vhost %q of the socket, want web-80", host)
+	}
+	if _, err := os.Stat(socket); err != nil {
+		t.Fatalf("no socket once the service is added: %v", err)
+	}
+
+	resolver.RemoveService(svc)
+	s.syncSockets()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("got %v for the socket once the service is removed", err)
+	}
+}
@@ -0,0 +1,155 @@
+package vhostserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/josudoey/kube"
+	"github.com/josudoey/kube/kubeutil"
+	"github.com/josudoey/kube/vhost"
+	corev1 "k8s.io/api/core/v1"
+	watch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// source serves the services of one namespace and label selector through
+// its own resolver and cache.
+type source struct {
+	context    string
+	namespace  string
+	selector   string
+	resolver   *vhost.PortForwardResolver
+	client     rest.Interface
+	restConfig *rest.Config
+	transport  http.RoundTripper
+	cancel     context.CancelFunc
+}
+
+// sourceSyncTimeout bounds the first list of the services and pods of a
+// source.
+const sourceSyncTimeout = time.Minute
+
+func sourceKey(ns NamespaceConfig) string {
+	return ns.Context + "/" + ns.Name + "/" + ns.Selector + "/" + ns.PodSelector + "/" + ns.FieldSelector
+}
+
+func (s *source) String() string {
+	name := "namespace " + s.namespace
+	if s.context != "" {
+		name = "context " + s.context + " " + name
+	}
+	return name
+}
+
+// startSource caches the services and pods of ns and keeps the resolver
+// up to date until the source is stopped. servicesChanged is called when a
+// service port is added or removed.
+func (o *KubeVhostServerOptions) startSource(ctx context.Context, kc *kubeClient, ns NamespaceConfig, servicesChanged func()) (*source, error) {
+	client := kc.client
+	restConfig := kc.restConfig
+	namespace := ns.Name
+	selector := ns.Selector
+
+	resolver := vhost.NewPortForwardResolver()
+	resolver.AffinityCookieName = o.affinityCookie
+	resolver.ServiceProxyFallback = o.serviceProxy
+	resolver.OnAddService = func(entry vhost.ServicePortEntry) {
+		servicesChanged()
+	}
+	resolver.OnRemoveService = func(entry vhost.ServicePortEntry) {
+		servicesChanged()
+	}
+	resolver.OnAddServiceBackend = func(entry vhost.ServicePortEntry, backend *vhost.PodBackend) {
+		sourceHostName := entry.SourceHostName()
+		targetHostPort := backend.GetTargetHostPort()
+		if o.verbose {
+			log.Printf("Add service backend %s -> %s", sourceHostName, targetHostPort)
+		}
+		backend.OnCreatePortForward = func() {
+			log.Printf("Created PortForward %s -> %s", sourceHostName, targetHostPort)
+		}
+		backend.OnClosePortForward = func() {
+			log.Printf("Closed PortForward %s -> %s", sourceHostName, targetHostPort)
+		}
+		backend.OnCreateStream = func(id int) {
+			log.Printf("Created Stream#%d %s", id, targetHostPort)
+		}
+		backend.OnCloseStream = func(id int) {
+			log.Printf("Closed Stream#%d %s", id, targetHostPort)
+		}
+	}
+
+	resolver.OnHostNameConflict = func(conflict vhost.HostNameConflict) {
+		log.Printf("Conflict: %v", conflict)
+	}
+
+	c := kube.NewCache(kc.clientset, 0,
+		kube.WithNamespace(namespace),
+		kube.WithLabelSelector(selector),
+	)
+	c.FilterPods(
+		kube.WithLabelSelector(ns.PodSelector),
+		kube.WithFieldSelector(ns.FieldSelector),
+	)
+	if err := kubeutil.Subscribe(resolver, c); err != nil {
+		return nil, err
+	}
+	if o.verbose {
+		c.AddPodHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				logPodEvent(watch.Added, obj)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				logPodEvent(watch.Modified, newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				logPodEvent(watch.Deleted, kube.DeletedObject(obj))
+			},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c.Start(ctx)
+	syncCtx, cancelSync := context.WithTimeout(ctx, sourceSyncTimeout)
+	defer cancelSync()
+	if err := c.WaitForSync(syncCtx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("%s: %v", namespace, err)
+	}
+
+	return &source{
+		context:    ns.Context,
+		namespace:  namespace,
+		selector:   selector,
+		resolver:   resolver,
+		client:     client.RESTClient(),
+		restConfig: restConfig,
+		transport:  resolver.NewRoundTripper(client.RESTClient(), restConfig, namespace),
+		cancel:     cancel,
+	}, nil
+}
+
+func logPodEvent(eventType watch.EventType, obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	ready := ""
+	if kube.IsPodReady(pod) {
+		ready = "(Ready)"
+	}
+	log.Printf("Event: %s %s%v %s", eventType, pod.Status.Phase, ready, pod.Name)
+}
+
+// stop ends the watches and drains the port-forward connections of the
+// source within timeout.
+func (s *source) stop(timeout time.Duration) error {
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.resolver.Shutdown(ctx)
+}
@@ -4,22 +4,29 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
-	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/josudoey/kube"
 	"github.com/josudoey/kube/kubeutil"
 	"github.com/josudoey/kube/vhost"
+	"github.com/josudoey/kube/vhost/har"
 	"github.com/spf13/cobra"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 )
 
 const (
-	defaultPort    = 8010
-	defaultAddress = "127.0.0.1"
+	defaultPort               = 8010
+	defaultAddress            = "127.0.0.1"
+	defaultIdleTimeout        = 10 * time.Minute
+	defaultShutdownTimeout    = 30 * time.Second
+	defaultConfigPollInterval = 2 * time.Second
+
+	contextHostPrefix = "prefix"
+	contextHostSuffix = "suffix"
 )
 
 type KubeVhostServerOptions struct {
@@ -27,138 +34,200 @@ type KubeVhostServerOptions struct {
 	address string
 	verbose bool
 
-	LabelSelector string
+	unixSocket      string
+	unixSocketDir   string
+	configFile      string
+	recordDir       string
+	recordMaxBody   int64
+	recordRedact    []string
+	intercepts      map[string]string
+	contexts        []string
+	contextHost     string
+	affinityCookie  string
+	serviceProxy    bool
+	maxConnections  int
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	LabelSelector   string
+	FieldSelector   string
 }
 
 func NewKubeVhostServerOptions() *KubeVhostServerOptions {
 	return &KubeVhostServerOptions{
-		port:    defaultPort,
-		address: defaultAddress,
+		port:            defaultPort,
+		contextHost:     contextHostSuffix,
+		recordMaxBody:   har.DefaultMaxBodySize,
+		recordRedact:    har.DefaultRedactHeaders,
+		address:         defaultAddress,
+		idleTimeout:     defaultIdleTimeout,
+		shutdownTimeout: defaultShutdownTimeout,
 	}
 }
 
-func (o *KubeVhostServerOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
-	selector := o.LabelSelector
-	namespace, _, err := f.ToRawKubeConfigLoader().Namespace()
-	if err != nil {
-		return err
-	}
-
-	client, err := kube.GetClient(f)
-	if err != nil {
-		return err
+// defaultNamespaces returns the namespaces served when the config file
+// does not list any: the namespace of each --context, or of the current
+// context, filtered by --selector and the pods of the TYPE/NAME in args.
+func (o *KubeVhostServerOptions) defaultNamespaces(clients *kubeClients, args []string) ([]NamespaceConfig, error) {
+	contexts := o.contexts
+	if len(contexts) == 0 {
+		contexts = []string{""}
 	}
 
-	ctx := context.Background()
-	resolver := vhost.NewPortForwardResolver()
-	resolver.OnAddServiceBackend = func(entry vhost.ServicePortEntry, backend *vhost.PodBackend) {
-		sourceHostName := entry.SourceHostName()
-		targetHostPort := backend.GetTargetHostPort()
-		if o.verbose {
-			log.Printf("Add service backend %s -> %s", sourceHostName, targetHostPort)
-		}
-		backend.OnCreatePortForward = func() {
-			log.Printf("Created PortForward %s -> %s", sourceHostName, targetHostPort)
+	namespaces := []NamespaceConfig{}
+	for _, contextName := range contexts {
+		namespace, err := clients.namespace(contextName)
+		if err != nil {
+			return nil, err
 		}
-		backend.OnClosePortForward = func() {
-			log.Printf("Closed PortForward %s -> %s", sourceHostName, targetHostPort)
+		ns := NamespaceConfig{
+			Context:       contextName,
+			Name:          namespace,
+			Selector:      o.LabelSelector,
+			FieldSelector: o.FieldSelector,
 		}
-		backend.OnCreateStream = func(id int) {
-			log.Printf("Created Stream#%d %s", id, targetHostPort)
+		if len(args) > 0 {
+			podSelector, podFieldSelector, err := kube.GetPodSelectorFor(clients.factory(contextName), namespace, "pods", args[0])
+			if err != nil {
+				return nil, err
+			}
+			ns.PodSelector = podSelector.String()
+			ns.FieldSelector = kube.MergeSelectors(ns.FieldSelector, podFieldSelector.String())
 		}
-		backend.OnCloseStream = func(id int) {
-			log.Printf("Closed Stream#%d %s", id, targetHostPort)
+		if len(contexts) > 1 {
+			switch o.contextHost {
+			case contextHostPrefix:
+				ns.HostPrefix = contextName + "."
+			default:
+				ns.HostSuffix = "." + contextName
+			}
 		}
+		namespaces = append(namespaces, ns)
 	}
+	return namespaces, nil
+}
 
-	_, err = kubeutil.PullServices(ctx, resolver, client,
-		kube.WithNamespace(namespace),
-		kube.WithLabelSelector(selector),
-	)
-	if err != nil {
-		return err
+func (o *KubeVhostServerOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	if o.contextHost != contextHostPrefix && o.contextHost != contextHostSuffix {
+		return fmt.Errorf("--context-host must be %q or %q", contextHostPrefix, contextHostSuffix)
 	}
 
-	podList, err := kubeutil.PullPods(ctx, resolver, client,
-		kube.WithNamespace(namespace),
-		kube.WithLabelSelector(selector),
-	)
-
+	vhost.SetMaxPortForwardConnections(o.maxConnections)
+	clients := newKubeClients(f)
+	namespaces, err := o.defaultNamespaces(clients, args)
 	if err != nil {
 		return err
 	}
 
-	watcher, err := kube.GetPodWatcher(ctx, client,
-		kube.WithNamespace(namespace),
-		kube.WithLabelSelector(selector),
-		kube.WithResourceVersion(podList.ResourceVersion),
-	)
+	config, err := o.loadConfig(namespaces)
 	if err != nil {
 		return err
 	}
-	defer watcher.Stop()
 
-	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		ch := watcher.ResultChan()
-		for e := range ch {
-			pod := kube.GetPod(e.Object)
-			if pod == nil {
-				continue
-			}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-			if o.verbose {
-				ready := ""
-				if kube.IsPodReady(pod) {
-					ready = "(Ready)"
-				}
-				log.Printf("Event: %s %s%v %s", e.Type, pod.Status.Phase, ready, pod.Name)
-			}
-			resolver.UpdatePod(pod)
+	server := o.newVhostServer(clients)
+	if o.recordDir != "" {
+		recorder, err := har.NewRecorder(o.recordDir)
+		if err != nil {
+			return err
 		}
-		cancel()
-	}()
-
-	config, err := f.ToRESTConfig()
-	if err != nil {
+		recorder.MaxBodySize = o.recordMaxBody
+		recorder.RedactHeaders = o.recordRedact
+		server.recorder = recorder
+	}
+	if err := server.apply(ctx, config); err != nil {
 		return err
 	}
 
-	mux := http.NewServeMux()
-	roundTripper := resolver.NewRoundTripper(client.RESTClient(), config, namespace)
-	for _, svc := range resolver.ListServices() {
-		name := svc.SourceHostName()
-		vhost, err := url.Parse("http://" + name)
+	reload := func() {
+		config, err := o.loadConfig(namespaces)
 		if err != nil {
-			continue
+			log.Printf("Reload failed, keeping the current config: %v", err)
+			return
 		}
-		rp := httputil.NewSingleHostReverseProxy(vhost)
-		rp.Transport = roundTripper
-		pattern := name + "/"
-		mux.HandleFunc(pattern, rp.ServeHTTP)
-		log.Printf("vhost port-forward %s -> svc/%s", name, svc.SourceHostPort())
+		if err := server.apply(ctx, config); err != nil {
+			log.Printf("Reload failed, keeping the current config: %v", err)
+			return
+		}
+		log.Printf("Reloaded config")
 	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", o.address, o.port))
-	if err != nil {
-		return err
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload()
+			}
+		}
+	}()
+
+	if o.configFile != "" {
+		go o.watchConfigFile(ctx, reload)
 	}
 
-	server := &http.Server{
-		Handler: resolver.GetGRPCHandler(mux, client.RESTClient(), config, namespace),
+	if o.idleTimeout > 0 {
+		go func() {
+			ticker := time.NewTicker(o.idleTimeout / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					for _, resolver := range server.resolvers() {
+						resolver.CloseIdle(o.idleTimeout)
+					}
+				}
+			}
+		}()
 	}
-	go server.Serve(l)
 	<-ctx.Done()
-	server.Close()
+
+	log.Printf("Shutting down, draining active streams")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancelShutdown()
+	server.shutdown(shutdownCtx)
 	return nil
 }
 
+// watchConfigFile calls reload whenever the modification time or size of
+// the config file changes.
+func (o *KubeVhostServerOptions) watchConfigFile(ctx context.Context, reload func()) {
+	last, _ := os.Stat(o.configFile)
+	ticker := time.NewTicker(defaultConfigPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(o.configFile)
+		if err != nil {
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		reload()
+	}
+}
+
 func NewCommand() *cobra.Command {
 	o := NewKubeVhostServerOptions()
 	f := kubeutil.DefaultFactory()
 
 	cmd := &cobra.Command{
-		Use: "server [--port=PORT]",
+		Use:  "server [--port=PORT] [TYPE/NAME]",
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdutil.CheckErr(o.Run(f, cmd, args))
 		},
@@ -167,6 +236,21 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().BoolVarP(&o.verbose, "verbose", "v", o.verbose, "Set verbose mode.")
 	cmd.Flags().IntVarP(&o.port, "port", "p", o.port, "The port on which to run the proxy. Set to 0 to pick a random port.")
 	cmd.Flags().StringVar(&o.address, "address", o.address, "The IP address on which to serve on.")
+	cmd.Flags().StringVar(&o.unixSocket, "unix-socket", o.unixSocket, "Also serve on this Unix socket, routed by Host like the TCP listener.")
+	cmd.Flags().StringVar(&o.unixSocketDir, "unix-socket-dir", o.unixSocketDir, "Also serve each vhost on its own Unix socket <dir>/<vhost>.sock, following the services as they are added and removed.")
+	cmd.Flags().StringVarP(&o.configFile, "config", "c", o.configFile, "Path to a YAML or JSON config file. It is reloaded on SIGHUP or when it changes.")
+	cmd.Flags().StringSliceVar(&o.contexts, "context", o.contexts, "The kubeconfig contexts to serve. With more than one context the vhost names get the context name as suffix or prefix (e.g. api-8080.staging).")
+	cmd.Flags().StringVar(&o.contextHost, "context-host", o.contextHost, "Where the context name goes in vhost names when serving several contexts: suffix or prefix.")
+	cmd.Flags().StringVar(&o.recordDir, "record", o.recordDir, "Record the proxied HTTP traffic of each vhost to <dir>/<vhost>.har.")
+	cmd.Flags().Int64Var(&o.recordMaxBody, "record-max-body-size", o.recordMaxBody, "The number of bytes of each request and response body to record.")
+	cmd.Flags().StringSliceVar(&o.recordRedact, "record-redact-header", o.recordRedact, "Headers whose values are replaced with REDACTED in recordings.")
+	cmd.Flags().StringToStringVar(&o.intercepts, "intercept", o.intercepts, "Send the traffic of a vhost to a local process instead of its pods (e.g. --intercept api-8080=localhost:3000).")
+	cmd.Flags().StringVar(&o.affinityCookie, "affinity-cookie", o.affinityCookie, "The cookie name used to keep HTTP clients on the same pod. Empty to disable cookie affinity.")
+	cmd.Flags().BoolVar(&o.serviceProxy, "service-proxy-fallback", o.serviceProxy, "Send HTTP requests through the API server service proxy when no pod of the service is ready or its port-forward fails.")
+	cmd.Flags().IntVar(&o.maxConnections, "max-port-forward-connections", o.maxConnections, "The maximum number of open port-forward connections to the API server. Set to 0 for no limit.")
+	cmd.Flags().DurationVar(&o.idleTimeout, "idle-timeout", o.idleTimeout, "Close port-forward connections that have had no streams for this long. Set to 0 to keep them open.")
+	cmd.Flags().DurationVar(&o.shutdownTimeout, "shutdown-timeout", o.shutdownTimeout, "How long to wait for active streams to drain on SIGINT or SIGTERM.")
 	cmd.Flags().StringVarP(&o.LabelSelector, "selector", "l", o.LabelSelector, "Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+	cmd.Flags().StringVar(&o.FieldSelector, "field-selector", o.FieldSelector, "Selector (field query) to filter pods on, supports '=', '==', and '!='.(e.g. --field-selector key1=value1,key2=value2). The server only supports a limited number of field queries per type.")
 	return cmd
 }
@@ -3,6 +3,8 @@ package vhostshow
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
 
 	"github.com/josudoey/kube"
 	"github.com/josudoey/kube/kubeutil"
@@ -14,6 +16,8 @@ import (
 
 type KubeVhostShowOptions struct {
 	LabelSelector string
+	FieldSelector string
+	AllNamespaces bool
 }
 
 func NewKubeVhostShowOptions() *KubeVhostShowOptions {
@@ -27,26 +31,89 @@ func (o *KubeVhostShowOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args [
 		return err
 	}
 
+	// a TYPE/NAME argument limits the pods, services are kept
+	podSelector := selector
+	podFieldSelector := o.FieldSelector
+	if len(args) > 0 {
+		refSelector, refFieldSelector, err := kube.GetPodSelectorFor(f, namespace, "pods", args[0])
+		if err != nil {
+			return err
+		}
+		podSelector = kube.MergeSelectors(selector, refSelector.String())
+		podFieldSelector = kube.MergeSelectors(podFieldSelector, refFieldSelector.String())
+	}
+
 	client, err := kube.GetClient(f)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
-	resolver := vhost.NewPortForwardResolver()
-
-	err = kubeutil.Pull(ctx, resolver, client,
+	opts := []kube.KubeOption{
 		kube.WithNamespace(namespace),
 		kube.WithLabelSelector(selector),
-	)
+	}
+	if o.AllNamespaces {
+		opts = append(opts, kube.WithAllNamespaces())
+	}
 
+	ctx := context.Background()
+	serviceList, err := kube.GetServiceList(ctx, client, opts...)
 	if err != nil {
 		return err
 	}
 
-	for _, svc := range resolver.ListServices() {
-		name := svc.SourceHostName()
-		fmt.Printf("%s -> svc/%s\n", name, svc.SourceHostPort())
+	// the field selector applies to pods, services lack most pod fields
+	podList, err := kube.GetPodList(ctx, client,
+		append(opts,
+			kube.WithLabelSelector(podSelector),
+			kube.WithFieldSelector(podFieldSelector),
+		)...,
+	)
+	if err != nil {
+		return err
+	}
+
+	// host names are unique within a namespace only
+	resolvers := map[string]*vhost.PortForwardResolver{}
+	for _, svc := range serviceList.Items {
+		resolver, ok := resolvers[svc.Namespace]
+		if !ok {
+			resolver = vhost.NewPortForwardResolver()
+			resolvers[svc.Namespace] = resolver
+		}
+		resolver.AddService(svc)
+	}
+	for _, pod := range podList.Items {
+		if resolver, ok := resolvers[pod.Namespace]; ok {
+			resolver.AddPod(pod)
+		}
+	}
+
+	namespaces := []string{}
+	for ns := range resolvers {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	for _, ns := range namespaces {
+		resolver := resolvers[ns]
+		for _, svc := range resolver.ListServices() {
+			for _, name := range resolver.ListHostNames(svc) {
+				if o.AllNamespaces {
+					fmt.Printf("%s\t%s -> svc/%s\n", ns, name, svc.SourceHostPort())
+					continue
+				}
+				fmt.Printf("%s -> svc/%s\n", name, svc.SourceHostPort())
+			}
+		}
+
+		for _, conflict := range resolver.Conflicts() {
+			if o.AllNamespaces {
+				fmt.Fprintf(os.Stderr, "conflict: %s: %v\n", ns, conflict)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "conflict: %v\n", conflict)
+		}
 	}
 
 	return nil
@@ -57,12 +124,15 @@ func NewCommand() *cobra.Command {
 	f := kubeutil.DefaultFactory()
 
 	cmd := &cobra.Command{
-		Use: "show",
+		Use:  "show [TYPE/NAME]",
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdutil.CheckErr(o.Run(f, cmd, args))
 		},
 	}
 
 	cmd.Flags().StringVarP(&o.LabelSelector, "selector", "l", o.LabelSelector, "Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+	cmd.Flags().StringVar(&o.FieldSelector, "field-selector", o.FieldSelector, "Selector (field query) to filter on, supports '=', '==', and '!='.(e.g. --field-selector key1=value1,key2=value2). The server only supports a limited number of field queries per type.")
+	cmd.Flags().BoolVarP(&o.AllNamespaces, "all-namespaces", "A", o.AllNamespaces, "If present, list the requested object(s) across all namespaces. Namespace in current context is ignored even if specified with --namespace.")
 	return cmd
 }
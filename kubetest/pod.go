@@ -0,0 +1,159 @@
+// Package kubetest builds fixtures for offline tests against the fake
+// clientset of client-go.
+package kubetest
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// DefaultImage is the image of the container of a pod fixture.
+const DefaultImage = "busybox"
+
+// NewClientset returns a fake clientset that holds objects.
+func NewClientset(objects ...runtime.Object) *fake.Clientset {
+	return fake.NewSimpleClientset(objects...)
+}
+
+type PodOption func(*corev1.Pod)
+
+// NewPod returns a pending pod of the default namespace with one
+// container named app. Its UID is derived from its namespace and name.
+func NewPod(name string, opts ...PodOption) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app", Image: DefaultImage},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+		},
+	}
+	for _, opt := range opts {
+		opt(pod)
+	}
+	if pod.UID == "" {
+		pod.UID = types.UID(pod.Namespace + "-" + pod.Name)
+	}
+	return pod
+}
+
+func WithPodNamespace(namespace string) PodOption {
+	return func(pod *corev1.Pod) {
+		pod.Namespace = namespace
+	}
+}
+
+func WithPodLabels(labels map[string]string) PodOption {
+	return func(pod *corev1.Pod) {
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			pod.Labels[k] = v
+		}
+	}
+}
+
+func WithPodIP(ip string) PodOption {
+	return func(pod *corev1.Pod) {
+		pod.Status.PodIP = ip
+		pod.Status.PodIPs = []corev1.PodIP{{IP: ip}}
+	}
+}
+
+// WithPodPort adds a container port to the first container, so that
+// services can target it by name.
+func WithPodPort(name string, port int32) PodOption {
+	return func(pod *corev1.Pod) {
+		container := &pod.Spec.Containers[0]
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          name,
+			ContainerPort: port,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+}
+
+// WithPodOwner makes owner, of kind gvk, the controller of the pod.
+func WithPodOwner(owner metav1.Object, gvk schema.GroupVersionKind) PodOption {
+	return func(pod *corev1.Pod) {
+		pod.OwnerReferences = append(pod.OwnerReferences, *metav1.NewControllerRef(owner, gvk))
+	}
+}
+
+// WithPodReady makes the pod running with every container started and
+// ready.
+func WithPodReady() PodOption {
+	return func(pod *corev1.Pod) {
+		started := true
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+			{Type: corev1.ContainersReady, Status: corev1.ConditionTrue},
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		}
+		pod.Status.ContainerStatuses = nil
+		for _, container := range pod.Spec.Containers {
+			pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+				Name:    container.Name,
+				Image:   container.Image,
+				Ready:   true,
+				Started: &started,
+				State: corev1.ContainerState{
+					Running: &corev1.ContainerStateRunning{},
+				},
+			})
+		}
+	}
+}
+
+// WithPodNotReady makes the pod pending with every container waiting for
+// reason, e.g. ImagePullBackOff or CrashLoopBackOff.
+func WithPodNotReady(reason, message string) PodOption {
+	return func(pod *corev1.Pod) {
+		started := false
+		pod.Status.Phase = corev1.PodPending
+		pod.Status.Conditions = []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+			{Type: corev1.ContainersReady, Status: corev1.ConditionFalse, Reason: "ContainersNotReady"},
+			{Type: corev1.PodReady, Status: corev1.ConditionFalse, Reason: "ContainersNotReady"},
+		}
+		pod.Status.ContainerStatuses = nil
+		for _, container := range pod.Spec.Containers {
+			pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+				Name:    container.Name,
+				Image:   container.Image,
+				Started: &started,
+				State: corev1.ContainerState{
+					Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message},
+				},
+			})
+		}
+	}
+}
+
+func WithPodPhase(phase corev1.PodPhase) PodOption {
+	return func(pod *corev1.Pod) {
+		pod.Status.Phase = phase
+	}
+}
+
+func WithPodResourceVersion(resourceVersion string) PodOption {
+	return func(pod *corev1.Pod) {
+		pod.ResourceVersion = resourceVersion
+	}
+}
@@ -0,0 +1,85 @@
+package kubetest
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type ServiceOption func(*corev1.Service)
+
+// NewService returns a ClusterIP service of the default namespace without
+// ports. Its UID is derived from its namespace and name.
+func NewService(name string, opts ...ServiceOption) *corev1.Service {
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if svc.UID == "" {
+		svc.UID = types.UID(svc.Namespace + "-" + svc.Name)
+	}
+	return svc
+}
+
+func WithServiceNamespace(namespace string) ServiceOption {
+	return func(svc *corev1.Service) {
+		svc.Namespace = namespace
+	}
+}
+
+func WithServiceLabels(labels map[string]string) ServiceOption {
+	return func(svc *corev1.Service) {
+		if svc.Labels == nil {
+			svc.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			svc.Labels[k] = v
+		}
+	}
+}
+
+func WithServiceSelector(selector map[string]string) ServiceOption {
+	return func(svc *corev1.Service) {
+		svc.Spec.Selector = selector
+	}
+}
+
+func WithServiceClusterIP(ip string) ServiceOption {
+	return func(svc *corev1.Service) {
+		svc.Spec.ClusterIP = ip
+	}
+}
+
+// WithServicePort adds a port that targets a numeric container port.
+func WithServicePort(name string, port, targetPort int32) ServiceOption {
+	return withServicePort(name, port, intstr.FromInt(int(targetPort)))
+}
+
+// WithServiceNamedPort adds a port that targets a container port by name.
+func WithServiceNamedPort(name string, port int32, targetPort string) ServiceOption {
+	return withServicePort(name, port, intstr.FromString(targetPort))
+}
+
+func withServicePort(name string, port int32, targetPort intstr.IntOrString) ServiceOption {
+	return func(svc *corev1.Service) {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:       name,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       port,
+			TargetPort: targetPort,
+		})
+	}
+}
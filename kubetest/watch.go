@@ -0,0 +1,141 @@
+package kubetest
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// WatchTimeout bounds how long a script waits for the client to open a
+// watch before it fails the test.
+var WatchTimeout = 10 * time.Second
+
+// watchBuffer is the number of events a watch holds before the script
+// blocks on the consumer.
+const watchBuffer = 100
+
+// WatchScript plays events to the watches of one resource of a fake
+// clientset. Every Watch call of the client opens a new watch. Events go
+// to the latest one and Close ends it, so that the client can watch
+// again, e.g. to resume after a server timeout.
+type WatchScript struct {
+	t      testing.TB
+	opened chan *watch.FakeWatcher
+
+	mu      sync.Mutex
+	current *watch.FakeWatcher
+
+	actionsLock sync.Mutex
+	actions     []k8stesting.WatchAction
+}
+
+// NewWatchScript takes over the watches of resource, e.g. "pods", on
+// client. A script may play its events from another goroutine than the
+// test; it then reports a watch the client never opens with t.Errorf.
+func NewWatchScript(t testing.TB, client *fake.Clientset, resource string) *WatchScript {
+	s := &WatchScript{
+		t:      t,
+		opened: make(chan *watch.FakeWatcher, watchBuffer),
+	}
+	client.PrependWatchReactor(resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFakeWithChanSize(watchBuffer, false)
+		if watchAction, ok := action.(k8stesting.WatchAction); ok {
+			s.actionsLock.Lock()
+			s.actions = append(s.actions, watchAction)
+			s.actionsLock.Unlock()
+		}
+		s.opened <- w
+		return true, w, nil
+	})
+	return s
+}
+
+// Actions returns the watch actions of the client so far, to check the
+// options it watched with.
+func (s *WatchScript) Actions() []k8stesting.WatchAction {
+	s.actionsLock.Lock()
+	defer s.actionsLock.Unlock()
+	return append([]k8stesting.WatchAction{}, s.actions...)
+}
+
+// watcher returns the open watch, waiting for the client to open one. It
+// returns nil when none is opened within WatchTimeout.
+func (s *WatchScript) watcher() *watch.FakeWatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		return s.current
+	}
+	timer := time.NewTimer(WatchTimeout)
+	defer timer.Stop()
+	select {
+	case w := <-s.opened:
+		s.current = w
+		return w
+	case <-timer.C:
+		// Fatalf must not be called from other goroutines than the test
+		s.t.Errorf("kubetest: no watch opened within %v", WatchTimeout)
+		return nil
+	}
+}
+
+// send plays an event to the open watch.
+func (s *WatchScript) send(eventType watch.EventType, object runtime.Object) *WatchScript {
+	if w := s.watcher(); w != nil {
+		w.Action(eventType, object)
+	}
+	return s
+}
+
+func (s *WatchScript) Add(object runtime.Object) *WatchScript {
+	return s.send(watch.Added, object)
+}
+
+func (s *WatchScript) Modify(object runtime.Object) *WatchScript {
+	return s.send(watch.Modified, object)
+}
+
+func (s *WatchScript) Delete(object runtime.Object) *WatchScript {
+	return s.send(watch.Deleted, object)
+}
+
+// Bookmark sends a bookmark, object carries only the resource version.
+func (s *WatchScript) Bookmark(object runtime.Object) *WatchScript {
+	return s.send(watch.Bookmark, object)
+}
+
+// Error sends status as an error event and ends the watch, as the server
+// does.
+func (s *WatchScript) Error(status *metav1.Status) *WatchScript {
+	return s.send(watch.Error, status).Close()
+}
+
+// Expire sends the 410 Gone error of an expired resource version.
+func (s *WatchScript) Expire() *WatchScript {
+	return s.Error(&metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusGone,
+		Reason:  metav1.StatusReasonExpired,
+		Message: "too old resource version",
+	})
+}
+
+// Close ends the open watch as the server does on a timeout.
+func (s *WatchScript) Close() *WatchScript {
+	w := s.watcher()
+	if w == nil {
+		return s
+	}
+	s.mu.Lock()
+	s.current = nil
+	s.mu.Unlock()
+	w.Stop()
+	return s
+}
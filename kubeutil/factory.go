@@ -29,3 +29,12 @@ func DefaultFactory() cmdutil.Factory {
 
 	return cmdutil.NewFactory(matchVersionKubeConfigFlags)
 }
+
+// ContextFactory returns a factory that uses the named kubeconfig context
+// instead of the current one.
+func ContextFactory(context string) cmdutil.Factory {
+	kubeConfigFlags := genericclioptions.NewConfigFlags(true).WithDeprecatedPasswordFlag()
+	kubeConfigFlags.Context = &context
+	matchVersionKubeConfigFlags := cmdutil.NewMatchVersionFlags(kubeConfigFlags)
+	return cmdutil.NewFactory(matchVersionKubeConfigFlags)
+}
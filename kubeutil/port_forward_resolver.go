@@ -2,19 +2,35 @@ package kubeutil
 
 import (
 	"context"
+	"errors"
 	"net"
 	"strconv"
+	"sync"
 
 	"github.com/josudoey/kube"
 	"github.com/josudoey/kube/vhost"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 )
 
+// PortForwardDialer dials addr, a vhost, through a port-forward to the pod
+// it resolves to. A failed forward is returned by the reads and writes of
+// the connection, e.g. a *vhost.PortForwardError.
 func PortForwardDialer(resolver *vhost.PortForwardResolver, restClient rest.Interface, config *rest.Config, namespace string) func(ctx context.Context, addr string) (net.Conn, error) {
 	return func(ctx context.Context, addr string) (net.Conn, error) {
+		hostname, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			hostname = addr
+		}
+		if target, ok := resolver.InterceptTarget(hostname); ok {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "tcp", target)
+		}
+
 		host, port, err := net.SplitHostPort(resolver.ResolveAddr(addr))
 		if err != nil {
 			return nil, err
@@ -24,15 +40,68 @@ func PortForwardDialer(resolver *vhost.PortForwardResolver, restClient rest.Inte
 			return nil, err
 		}
 		local, remote := net.Pipe()
+		forwarded := &forwardConn{Conn: local}
 		go func() {
-			defer local.Close()
+			defer remote.Close()
 			p, _ := strconv.ParseUint(port, 10, 16)
-			conn.Forward(remote, uint16(p), nil)
+			// the error must be set before the pipe closes, so that the
+			// reader gets it in place of io.EOF
+			forwarded.fail(conn.Forward(keepOpenConn{remote}, uint16(p), nil))
 		}()
-		return local, nil
+		return forwarded, nil
 	}
 }
 
+// forwardConn is the local end of a forwarded port. Once the forward has
+// failed, reads and writes return its error, e.g. a *vhost.PortForwardError
+// of a port the pod does not listen on, in place of io.EOF.
+type forwardConn struct {
+	net.Conn
+
+	lock sync.Mutex
+	err  error
+}
+
+func (c *forwardConn) fail(err error) {
+	c.lock.Lock()
+	c.err = err
+	c.lock.Unlock()
+}
+
+func (c *forwardConn) failure(err error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+func (c *forwardConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		err = c.failure(err)
+	}
+	return n, err
+}
+
+func (c *forwardConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		err = c.failure(err)
+	}
+	return n, err
+}
+
+// keepOpenConn leaves the closing of a connection to its owner.
+type keepOpenConn struct {
+	net.Conn
+}
+
+func (keepOpenConn) Close() error {
+	return nil
+}
+
 func PullServices(ctx context.Context, resolver *vhost.PortForwardResolver, client coreclient.ServicesGetter, opts ...kube.KubeOption) (*v1.ServiceList, error) {
 	serviceList, err := kube.GetServiceList(ctx, client,
 		opts...,
@@ -89,3 +158,55 @@ func NewPortForwardResolverAndPull(f cmdutil.Factory, opts ...kube.KubeOption) (
 
 	return resolver, nil
 }
+
+// Subscribe keeps resolver up to date with the services and pods of c.
+// Call it before c is started. The resolver knows pods by name, so c
+// must be of a single namespace.
+func Subscribe(resolver *vhost.PortForwardResolver, c *kube.Cache) error {
+	if c.Namespace() == metav1.NamespaceAll {
+		return errors.New("cannot subscribe a resolver to a cache of all namespaces")
+	}
+	c.AddServiceHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if svc, ok := obj.(*v1.Service); ok {
+				resolver.AddService(*svc)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSvc, ok := oldObj.(*v1.Service)
+			if !ok {
+				return
+			}
+			newSvc, ok := newObj.(*v1.Service)
+			if !ok || newSvc.ResourceVersion == oldSvc.ResourceVersion {
+				return
+			}
+			// ports and selector may have changed, rebuild the entries
+			resolver.RemoveService(*oldSvc)
+			resolver.AddService(*newSvc)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if svc, ok := kube.DeletedObject(obj).(*v1.Service); ok {
+				resolver.RemoveService(*svc)
+			}
+		},
+	})
+	c.AddPodHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				resolver.UpdatePod(pod)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if pod, ok := newObj.(*v1.Pod); ok {
+				resolver.UpdatePod(pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if pod, ok := kube.DeletedObject(obj).(*v1.Pod); ok {
+				resolver.DeleteByName(pod.GetName())
+			}
+		},
+	})
+	return nil
+}
@@ -1,15 +1,53 @@
 package kube
 
 import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
 // see https://github.com/kubernetes/kubectl/blob/master/pkg/polymorphichelpers/helpers.go#L84
 
+// ToTyped converts an unstructured object to its typed counterpart
+// through the client-go scheme. Other objects are returned unchanged.
+func ToTyped(object runtime.Object) (runtime.Object, error) {
+	u, ok := object.(*unstructured.Unstructured)
+	if !ok {
+		return object, nil
+	}
+	typed, err := scheme.Scheme.New(u.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
+
+// typed returns the typed form of object, or object itself when it
+// cannot be converted.
+func typed(object runtime.Object) runtime.Object {
+	t, err := ToTyped(object)
+	if err != nil {
+		return object
+	}
+	return t
+}
+
 // GetPod
 func GetPod(object runtime.Object) *corev1.Pod {
-	switch t := object.(type) {
+	switch t := typed(object).(type) {
 	case *corev1.Pod:
 		return t
 	}
@@ -18,9 +56,166 @@ func GetPod(object runtime.Object) *corev1.Pod {
 
 // GetService
 func GetService(object runtime.Object) *corev1.Service {
-	switch t := object.(type) {
+	switch t := typed(object).(type) {
 	case *corev1.Service:
 		return t
 	}
 	return nil
 }
+
+// GetDeployment
+func GetDeployment(object runtime.Object) *appsv1.Deployment {
+	switch t := typed(object).(type) {
+	case *appsv1.Deployment:
+		return t
+	}
+	return nil
+}
+
+// GetStatefulSet
+func GetStatefulSet(object runtime.Object) *appsv1.StatefulSet {
+	switch t := typed(object).(type) {
+	case *appsv1.StatefulSet:
+		return t
+	}
+	return nil
+}
+
+// GetReplicaSet
+func GetReplicaSet(object runtime.Object) *appsv1.ReplicaSet {
+	switch t := typed(object).(type) {
+	case *appsv1.ReplicaSet:
+		return t
+	}
+	return nil
+}
+
+// GetDaemonSet
+func GetDaemonSet(object runtime.Object) *appsv1.DaemonSet {
+	switch t := typed(object).(type) {
+	case *appsv1.DaemonSet:
+		return t
+	}
+	return nil
+}
+
+// GetReplicationController
+func GetReplicationController(object runtime.Object) *corev1.ReplicationController {
+	switch t := typed(object).(type) {
+	case *corev1.ReplicationController:
+		return t
+	}
+	return nil
+}
+
+// GetJob
+func GetJob(object runtime.Object) *batchv1.Job {
+	switch t := typed(object).(type) {
+	case *batchv1.Job:
+		return t
+	}
+	return nil
+}
+
+// GetCronJob
+func GetCronJob(object runtime.Object) *batchv1.CronJob {
+	switch t := typed(object).(type) {
+	case *batchv1.CronJob:
+		return t
+	}
+	return nil
+}
+
+// GetEndpoints
+func GetEndpoints(object runtime.Object) *corev1.Endpoints {
+	switch t := typed(object).(type) {
+	case *corev1.Endpoints:
+		return t
+	}
+	return nil
+}
+
+// GetEndpointSlice
+func GetEndpointSlice(object runtime.Object) *discoveryv1.EndpointSlice {
+	switch t := typed(object).(type) {
+	case *discoveryv1.EndpointSlice:
+		return t
+	}
+	return nil
+}
+
+// GetEvent
+func GetEvent(object runtime.Object) *corev1.Event {
+	switch t := typed(object).(type) {
+	case *corev1.Event:
+		return t
+	}
+	return nil
+}
+
+// GetPodsForObject returns the pods of a pod, a service or a workload:
+// the pods its selector matches, the pods of the jobs of a cron job or,
+// for objects without a selector, the pods it owns.
+func GetPodsForObject(ctx context.Context, client kubernetes.Interface, object runtime.Object) ([]*corev1.Pod, error) {
+	object = typed(object)
+	if pod := GetPod(object); pod != nil {
+		return []*corev1.Pod{pod}, nil
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return nil, err
+	}
+	namespace := accessor.GetNamespace()
+
+	// the pods of a cron job are owned by its jobs
+	if cronJob := GetCronJob(object); cronJob != nil {
+		return getCronJobPods(ctx, client, cronJob)
+	}
+
+	if _, selector, err := SelectorsForObject(object); err == nil {
+		return GetPods(ctx, client.CoreV1(),
+			WithNamespace(namespace),
+			WithLabelSelector(selector.String()),
+		)
+	}
+
+	if accessor.GetUID() == "" {
+		return nil, fmt.Errorf("cannot find the pods of %T %s", object, accessor.GetName())
+	}
+	pods, err := GetPods(ctx, client.CoreV1(), WithNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	owned := []*corev1.Pod{}
+	for _, pod := range pods {
+		for _, ref := range pod.OwnerReferences {
+			if ref.UID == accessor.GetUID() {
+				owned = append(owned, pod)
+				break
+			}
+		}
+	}
+	return owned, nil
+}
+
+// getCronJobPods returns the pods of the jobs that cronJob owns.
+func getCronJobPods(ctx context.Context, client kubernetes.Interface, cronJob *batchv1.CronJob) ([]*corev1.Pod, error) {
+	jobs, err := GetJobList(ctx, client.BatchV1(), WithNamespace(cronJob.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	pods := []*corev1.Pod{}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !metav1.IsControlledBy(job, cronJob) {
+			continue
+		}
+		jobPods, err := GetPodsForObject(ctx, client, job)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, jobPods...)
+	}
+	return pods, nil
+}
@@ -0,0 +1,93 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func podNames(pods []*corev1.Pod) []string {
+	names := []string{}
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newJob returns a job of the pods labeled with its name, owned by
+// cronJob when set.
+func newJob(name string, cronJob *batchv1.CronJob) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault, UID: "uid-" + types.UID(name)},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": name}},
+		},
+	}
+	if cronJob != nil {
+		job.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(cronJob, batchv1.SchemeGroupVersion.WithKind("CronJob")),
+		}
+	}
+	return job
+}
+
+func newJobPod(name, job string) *corev1.Pod {
+	return kubetest.NewPod(name, kubetest.WithPodLabels(map[string]string{"job-name": job}))
+}
+
+func TestGetPodsForObject(t *testing.T) {
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: metav1.NamespaceDefault, UID: "uid-backup"},
+	}
+	web := kubetest.NewService("web", kubetest.WithServiceSelector(map[string]string{"app": "web"}))
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}},
+		},
+	}}
+	client := kubetest.NewClientset(
+		kubetest.NewPod("web-0", kubetest.WithPodLabels(map[string]string{"app": "web"})),
+		newJob("backup-1", cronJob),
+		newJob("backup-2", cronJob),
+		newJob("migrate", nil),
+		newJobPod("backup-1-a", "backup-1"),
+		newJobPod("backup-2-a", "backup-2"),
+		newJobPod("migrate-a", "migrate"),
+	)
+
+	tests := []struct {
+		name   string
+		object runtime.Object
+		want   []string
+	}{
+		{name: "pod", object: kubetest.NewPod("web-0"), want: []string{"web-0"}},
+		{name: "service", object: web, want: []string{"web-0"}},
+		{name: "unstructured deployment", object: deployment, want: []string{"web-0"}},
+		{name: "job", object: newJob("migrate", nil), want: []string{"migrate-a"}},
+		{name: "cron job", object: cronJob, want: []string{"backup-1-a", "backup-2-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods, err := GetPodsForObject(context.Background(), client, tt.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := podNames(pods); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
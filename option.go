@@ -1,9 +1,20 @@
 package kube
 
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 type KubeOptions struct {
 	Namespace       string
+	AllNamespaces   bool
 	LabelSelector   string
+	FieldSelector   string
 	ResourceVersion string
+	Limit           int64
+	PageSize        int64
+	Timeout         time.Duration
 }
 
 type KubeOption func(*KubeOptions)
@@ -11,6 +22,7 @@ type KubeOption func(*KubeOptions)
 func NewKubeOptions(opts []KubeOption) *KubeOptions {
 	o := &KubeOptions{
 		Namespace: "default",
+		PageSize:  DefaultPageSize,
 	}
 	for _, opt := range opts {
 		opt(o)
@@ -18,20 +30,83 @@ func NewKubeOptions(opts []KubeOption) *KubeOptions {
 	return o
 }
 
+// ListOptions returns the list and watch options of o.
+func (o *KubeOptions) ListOptions() metav1.ListOptions {
+	options := metav1.ListOptions{
+		LabelSelector:   o.LabelSelector,
+		FieldSelector:   o.FieldSelector,
+		ResourceVersion: o.ResourceVersion,
+		Limit:           o.Limit,
+	}
+	if o.Timeout > 0 {
+		seconds := int64((o.Timeout + time.Second - 1) / time.Second)
+		options.TimeoutSeconds = &seconds
+	}
+	return options
+}
+
+// listNamespace returns the namespace the list and watch helpers query,
+// metav1.NamespaceAll for all namespaces.
+func (o *KubeOptions) listNamespace() string {
+	if o.AllNamespaces {
+		return metav1.NamespaceAll
+	}
+	return o.Namespace
+}
+
 func WithNamespace(namespace string) KubeOption {
 	return func(o *KubeOptions) {
 		o.Namespace = namespace
 	}
 }
 
+// WithAllNamespaces lists and watches across all namespaces, ignoring
+// the namespace option.
+func WithAllNamespaces() KubeOption {
+	return func(o *KubeOptions) {
+		o.AllNamespaces = true
+	}
+}
+
 func WithLabelSelector(selector string) KubeOption {
 	return func(o *KubeOptions) {
 		o.LabelSelector = selector
 	}
 }
 
+// WithFieldSelector filters by fields, e.g. spec.nodeName=node-1 or
+// status.phase=Running.
+func WithFieldSelector(selector string) KubeOption {
+	return func(o *KubeOptions) {
+		o.FieldSelector = selector
+	}
+}
+
 func WithResourceVersion(resourceVersion string) KubeOption {
 	return func(o *KubeOptions) {
 		o.ResourceVersion = resourceVersion
 	}
 }
+
+// WithLimit caps the number of items a list returns.
+func WithLimit(limit int64) KubeOption {
+	return func(o *KubeOptions) {
+		o.Limit = limit
+	}
+}
+
+// WithPageSize sets the number of items fetched per list request. Zero
+// lists everything in one request.
+func WithPageSize(pageSize int64) KubeOption {
+	return func(o *KubeOptions) {
+		o.PageSize = pageSize
+	}
+}
+
+// WithTimeout bounds a list or watch call on the server side, rounded up
+// to whole seconds.
+func WithTimeout(timeout time.Duration) KubeOption {
+	return func(o *KubeOptions) {
+		o.Timeout = timeout
+	}
+}
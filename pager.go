@@ -0,0 +1,110 @@
+package kube
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DefaultPageSize is the number of items the list helpers fetch per
+// request.
+const DefaultPageSize = 500
+
+// listFunc lists one page of a resource.
+type listFunc func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error)
+
+// pageLimit returns the limit of the next page once count items were
+// returned.
+func (o *KubeOptions) pageLimit(count int64) int64 {
+	limit := o.PageSize
+	if o.Limit > 0 {
+		remaining := o.Limit - count
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// eachPage calls fn with every page of a list, following the continue
+// tokens until the list ends or o.Limit items were returned. When a
+// continue token expires (410 Gone) the next page is fetched with the
+// token the API server offers for an inconsistent continuation, or else
+// the list starts over and the items returned before are left out. The
+// API server lists in key order, so the items up to the key of the last
+// returned item are the ones returned before.
+// see https://kubernetes.io/docs/reference/using-api/api-concepts/#retrieving-large-results-sets-in-chunks
+func eachPage(ctx context.Context, o *KubeOptions, list listFunc, fn func(page runtime.Object) error) error {
+	options := o.ListOptions()
+	relisted := false
+	// last is the key of the last returned item, after is the key up to
+	// which a restarted list returns items again
+	last, after := "", ""
+	var count int64
+	for {
+		options.Limit = o.pageLimit(count)
+		if relisted {
+			// pages of a restarted list hold items returned before
+			options.Limit = o.pageLimit(0)
+		}
+		page, err := list(ctx, options)
+		if apierrors.IsResourceExpired(err) && options.Continue != "" {
+			options.Continue = ""
+			options.ResourceVersion = o.ResourceVersion
+			relisted = true
+			after = last
+			if status, ok := err.(apierrors.APIStatus); ok && status.Status().ListMeta.Continue != "" {
+				options.Continue = status.Status().ListMeta.Continue
+				options.ResourceVersion = ""
+				relisted = false
+				after = ""
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		items, err := meta.ExtractList(page)
+		if err != nil {
+			return err
+		}
+		kept := []runtime.Object{}
+		for _, item := range items {
+			if o.Limit > 0 && count >= o.Limit {
+				break
+			}
+			accessor, err := meta.Accessor(item)
+			if err != nil {
+				return err
+			}
+			key := accessor.GetNamespace() + "/" + accessor.GetName()
+			if after != "" && key <= after {
+				continue
+			}
+			last = key
+			kept = append(kept, item)
+			count++
+		}
+		if err := meta.SetList(page, kept); err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		listMeta, err := meta.ListAccessor(page)
+		if err != nil {
+			return err
+		}
+		if listMeta.GetContinue() == "" || (o.Limit > 0 && count >= o.Limit) {
+			return nil
+		}
+		options.Continue = listMeta.GetContinue()
+		// the continue token carries the resource version of the first page
+		options.ResourceVersion = ""
+	}
+}
@@ -0,0 +1,156 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// pagedPods lists pods in pages whose continue token is the index of the
+// next pod. expire lists the calls that fail with 410 Gone, offering
+// inconsistent as the continue token of the error, after calling
+// onExpire to change the pods meanwhile.
+type pagedPods struct {
+	pods         []corev1.Pod
+	expire       map[int]bool
+	onExpire     func()
+	inconsistent string
+	calls        []metav1.ListOptions
+}
+
+func (p *pagedPods) list(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+	call := len(p.calls)
+	p.calls = append(p.calls, options)
+	if p.expire[call] {
+		if p.onExpire != nil {
+			p.onExpire()
+		}
+		err := apierrors.NewResourceExpired("continue token expired")
+		err.ErrStatus.ListMeta.Continue = p.inconsistent
+		return nil, err
+	}
+
+	start := 0
+	if options.Continue != "" {
+		start, _ = strconv.Atoi(options.Continue)
+	}
+	end := len(p.pods)
+	if options.Limit > 0 && start+int(options.Limit) < end {
+		end = start + int(options.Limit)
+	}
+	list := &corev1.PodList{Items: append([]corev1.Pod{}, p.pods[start:end]...)}
+	list.ResourceVersion = "10"
+	if end < len(p.pods) {
+		list.Continue = strconv.Itoa(end)
+	}
+	return list, nil
+}
+
+func newPagedPods(names ...string) *pagedPods {
+	p := &pagedPods{}
+	for _, name := range names {
+		p.pods = append(p.pods, *kubetest.NewPod(name))
+	}
+	return p
+}
+
+func collectPages(t *testing.T, o *KubeOptions, p *pagedPods) []string {
+	t.Helper()
+	names := []string{}
+	err := eachPage(context.Background(), o, p.list, func(page runtime.Object) error {
+		for _, pod := range page.(*corev1.PodList).Items {
+			names = append(names, pod.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("eachPage: %v", err)
+	}
+	return names
+}
+
+func TestEachPageFollowsContinue(t *testing.T) {
+	p := newPagedPods("a", "b", "c", "d", "e")
+	names := collectPages(t, NewKubeOptions([]KubeOption{WithPageSize(2)}), p)
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if len(p.calls) != 3 {
+		t.Fatalf("got %d list calls, want 3", len(p.calls))
+	}
+	if p.calls[1].Continue != "2" || p.calls[2].Continue != "4" {
+		t.Errorf("got continue tokens %q and %q, want 2 and 4", p.calls[1].Continue, p.calls[2].Continue)
+	}
+}
+
+func TestEachPageStopsAtLimit(t *testing.T) {
+	p := newPagedPods("a", "b", "c", "d", "e")
+	names := collectPages(t, NewKubeOptions([]KubeOption{WithPageSize(2), WithLimit(3)}), p)
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if p.calls[1].Limit != 1 {
+		t.Errorf("got limit %d of the last page, want 1", p.calls[1].Limit)
+	}
+}
+
+func TestEachPageRelistsExpiredContinue(t *testing.T) {
+	p := newPagedPods("a", "b", "c", "d", "e")
+	p.expire = map[int]bool{1: true}
+	names := collectPages(t, NewKubeOptions([]KubeOption{WithPageSize(2)}), p)
+	// the restarted list returns a and b again, which are left out
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if p.calls[2].Continue != "" {
+		t.Errorf("got continue %q after 410, want the list to start over", p.calls[2].Continue)
+	}
+}
+
+func TestEachPageRelistSkipsByKey(t *testing.T) {
+	p := newPagedPods("a", "b", "c", "d", "e")
+	p.expire = map[int]bool{1: true}
+	// a is deleted and bb created before the list starts over
+	p.onExpire = func() {
+		p.pods = append(newPagedPods("b", "bb").pods, p.pods[2:]...)
+	}
+	names := collectPages(t, NewKubeOptions([]KubeOption{WithPageSize(2)}), p)
+	if want := []string{"a", "b", "bb", "c", "d", "e"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestEachPageUsesInconsistentContinue(t *testing.T) {
+	p := newPagedPods("a", "b", "c", "d", "e")
+	p.expire = map[int]bool{1: true}
+	p.inconsistent = "2"
+	names := collectPages(t, NewKubeOptions([]KubeOption{WithPageSize(2)}), p)
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if len(p.calls) != 4 || p.calls[2].Continue != "2" {
+		t.Errorf("got calls %+v, want the offered continue token after 410", p.calls)
+	}
+}
+
+func TestEachPageReturnsOtherErrors(t *testing.T) {
+	o := NewKubeOptions(nil)
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "")
+	err := eachPage(context.Background(), o, func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+		return nil, notFound
+	}, func(page runtime.Object) error {
+		t.Error("fn called without a page")
+		return nil
+	})
+	if err != notFound {
+		t.Errorf("got %v, want %v", err, notFound)
+	}
+}
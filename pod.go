@@ -4,17 +4,34 @@ import (
 	"context"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/kubectl/pkg/util/podutils"
 )
 
 var IsPodReady = podutils.IsPodReady
 
+// GetPodList returns the pods of every page of the list in one PodList.
 func GetPodList(ctx context.Context, client coreclient.PodsGetter, opts ...KubeOption) (*corev1.PodList, error) {
-	o := NewKubeOptions(opts)
-	options := metav1.ListOptions{LabelSelector: o.LabelSelector}
-	return client.Pods(o.Namespace).List(ctx, options)
+	podList := &corev1.PodList{}
+	if err := getList(ctx, "pods", client, podList, opts); err != nil {
+		return nil, err
+	}
+	return podList, nil
+}
+
+// EachPod calls fn with the pods of the list page by page, without
+// holding the whole list in memory. It stops at the first error of fn.
+func EachPod(ctx context.Context, client coreclient.PodsGetter, fn func(pod *corev1.Pod) error, opts ...KubeOption) error {
+	return eachItem(ctx, "pods", client, opts, func(page runtime.Object) error {
+		pods := page.(*corev1.PodList)
+		for i := range pods.Items {
+			if err := fn(&pods.Items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // GetFirstPod returns a pod matching the namespace and label selector
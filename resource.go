@@ -0,0 +1,252 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	appsclient "k8s.io/client-go/kubernetes/typed/apps/v1"
+	batchclient "k8s.io/client-go/kubernetes/typed/batch/v1"
+	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
+	discoveryclient "k8s.io/client-go/kubernetes/typed/discovery/v1"
+)
+
+// watchFunc watches one kind of object.
+type watchFunc func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error)
+
+// resource lists and watches one kind of object in a namespace, like
+// cache.ListWatch.
+type resource struct {
+	list  listFunc
+	watch watchFunc
+}
+
+// typedClient is the typed client of one kind, e.g. a PodInterface. Its
+// List returns the typed list of the kind.
+type typedClient interface {
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// typedClients returns the typed client of a resource in a namespace from
+// the getter of the resource, e.g. PodsGetter.Pods for "pods". Cluster
+// scoped resources ignore the namespace.
+var typedClients = map[string]func(getter interface{}, namespace string) typedClient{
+	"pods": func(getter interface{}, namespace string) typedClient {
+		return getter.(coreclient.PodsGetter).Pods(namespace)
+	},
+	"services": func(getter interface{}, namespace string) typedClient {
+		return getter.(coreclient.ServicesGetter).Services(namespace)
+	},
+	"endpoints": func(getter interface{}, namespace string) typedClient {
+		return getter.(coreclient.EndpointsGetter).Endpoints(namespace)
+	},
+	"endpointslices": func(getter interface{}, namespace string) typedClient {
+		return getter.(discoveryclient.EndpointSlicesGetter).EndpointSlices(namespace)
+	},
+	"deployments": func(getter interface{}, namespace string) typedClient {
+		return getter.(appsclient.DeploymentsGetter).Deployments(namespace)
+	},
+	"statefulsets": func(getter interface{}, namespace string) typedClient {
+		return getter.(appsclient.StatefulSetsGetter).StatefulSets(namespace)
+	},
+	"replicasets": func(getter interface{}, namespace string) typedClient {
+		return getter.(appsclient.ReplicaSetsGetter).ReplicaSets(namespace)
+	},
+	"jobs": func(getter interface{}, namespace string) typedClient {
+		return getter.(batchclient.JobsGetter).Jobs(namespace)
+	},
+	"events": func(getter interface{}, namespace string) typedClient {
+		return getter.(coreclient.EventsGetter).Events(namespace)
+	},
+	"configmaps": func(getter interface{}, namespace string) typedClient {
+		return getter.(coreclient.ConfigMapsGetter).ConfigMaps(namespace)
+	},
+	"nodes": func(getter interface{}, namespace string) typedClient {
+		return getter.(coreclient.NodesGetter).Nodes()
+	},
+}
+
+// newResource returns the resource of name in namespace, read through
+// getter. The List methods of the typed clients differ by their list type
+// only, so List is called by reflection.
+func newResource(name string, getter interface{}, namespace string) resource {
+	c := typedClients[name](getter, namespace)
+	list := reflect.ValueOf(c).MethodByName("List")
+	return resource{
+		list: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			out := list.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(options)})
+			if err, _ := out[1].Interface().(error); err != nil {
+				return nil, err
+			}
+			return out[0].Interface().(runtime.Object), nil
+		},
+		watch: c.Watch,
+	}
+}
+
+// getList fills into, an empty list of the resource name, with the items
+// of every page of the list.
+func getList(ctx context.Context, name string, getter interface{}, into runtime.Object, opts []KubeOption) error {
+	o := NewKubeOptions(opts)
+	items := []runtime.Object{}
+	resourceVersion := ""
+	err := eachPage(ctx, o, newResource(name, getter, o.listNamespace()).list, func(page runtime.Object) error {
+		pageItems, err := meta.ExtractList(page)
+		if err != nil {
+			return err
+		}
+		items = append(items, pageItems...)
+		if resourceVersion == "" {
+			listMeta, err := meta.ListAccessor(page)
+			if err != nil {
+				return err
+			}
+			resourceVersion = listMeta.GetResourceVersion()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := meta.SetList(into, items); err != nil {
+		return err
+	}
+	listMeta, err := meta.ListAccessor(into)
+	if err != nil {
+		return err
+	}
+	listMeta.SetResourceVersion(resourceVersion)
+	return nil
+}
+
+// eachItem calls fn with the list of the resource name page by page,
+// without holding the whole list in memory. It stops at the first error
+// of fn.
+func eachItem(ctx context.Context, name string, getter interface{}, opts []KubeOption, fn func(page runtime.Object) error) error {
+	o := NewKubeOptions(opts)
+	return eachPage(ctx, o, newResource(name, getter, o.listNamespace()).list, fn)
+}
+
+// getWatcher watches the objects of the resource name.
+func getWatcher(ctx context.Context, name string, getter interface{}, opts []KubeOption) (watch.Interface, error) {
+	o := NewKubeOptions(opts)
+	return newResource(name, getter, o.listNamespace()).watch(ctx, o.ListOptions())
+}
+
+func GetEndpointsList(ctx context.Context, client coreclient.EndpointsGetter, opts ...KubeOption) (*corev1.EndpointsList, error) {
+	list := &corev1.EndpointsList{}
+	if err := getList(ctx, "endpoints", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetEndpointsWatcher(ctx context.Context, client coreclient.EndpointsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "endpoints", client, opts)
+}
+
+func GetEndpointSliceList(ctx context.Context, client discoveryclient.EndpointSlicesGetter, opts ...KubeOption) (*discoveryv1.EndpointSliceList, error) {
+	list := &discoveryv1.EndpointSliceList{}
+	if err := getList(ctx, "endpointslices", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetEndpointSliceWatcher(ctx context.Context, client discoveryclient.EndpointSlicesGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "endpointslices", client, opts)
+}
+
+func GetDeploymentList(ctx context.Context, client appsclient.DeploymentsGetter, opts ...KubeOption) (*appsv1.DeploymentList, error) {
+	list := &appsv1.DeploymentList{}
+	if err := getList(ctx, "deployments", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetDeploymentWatcher(ctx context.Context, client appsclient.DeploymentsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "deployments", client, opts)
+}
+
+func GetStatefulSetList(ctx context.Context, client appsclient.StatefulSetsGetter, opts ...KubeOption) (*appsv1.StatefulSetList, error) {
+	list := &appsv1.StatefulSetList{}
+	if err := getList(ctx, "statefulsets", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetStatefulSetWatcher(ctx context.Context, client appsclient.StatefulSetsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "statefulsets", client, opts)
+}
+
+func GetReplicaSetList(ctx context.Context, client appsclient.ReplicaSetsGetter, opts ...KubeOption) (*appsv1.ReplicaSetList, error) {
+	list := &appsv1.ReplicaSetList{}
+	if err := getList(ctx, "replicasets", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetReplicaSetWatcher(ctx context.Context, client appsclient.ReplicaSetsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "replicasets", client, opts)
+}
+
+func GetJobList(ctx context.Context, client batchclient.JobsGetter, opts ...KubeOption) (*batchv1.JobList, error) {
+	list := &batchv1.JobList{}
+	if err := getList(ctx, "jobs", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetJobWatcher(ctx context.Context, client batchclient.JobsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "jobs", client, opts)
+}
+
+func GetEventList(ctx context.Context, client coreclient.EventsGetter, opts ...KubeOption) (*corev1.EventList, error) {
+	list := &corev1.EventList{}
+	if err := getList(ctx, "events", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetEventWatcher(ctx context.Context, client coreclient.EventsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "events", client, opts)
+}
+
+func GetConfigMapList(ctx context.Context, client coreclient.ConfigMapsGetter, opts ...KubeOption) (*corev1.ConfigMapList, error) {
+	list := &corev1.ConfigMapList{}
+	if err := getList(ctx, "configmaps", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetConfigMapWatcher(ctx context.Context, client coreclient.ConfigMapsGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "configmaps", client, opts)
+}
+
+// GetNodeList lists the nodes of the cluster. The namespace options are
+// ignored.
+func GetNodeList(ctx context.Context, client coreclient.NodesGetter, opts ...KubeOption) (*corev1.NodeList, error) {
+	list := &corev1.NodeList{}
+	if err := getList(ctx, "nodes", client, list, opts); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func GetNodeWatcher(ctx context.Context, client coreclient.NodesGetter, opts ...KubeOption) (watch.Interface, error) {
+	return getWatcher(ctx, "nodes", client, opts)
+}
@@ -0,0 +1,106 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestTypedClients(t *testing.T) {
+	client := kubetest.NewClientset(kubetest.NewPod("web-0"))
+	getters := map[string]interface{}{
+		"pods":           client.CoreV1(),
+		"services":       client.CoreV1(),
+		"endpoints":      client.CoreV1(),
+		"endpointslices": client.DiscoveryV1(),
+		"deployments":    client.AppsV1(),
+		"statefulsets":   client.AppsV1(),
+		"replicasets":    client.AppsV1(),
+		"jobs":           client.BatchV1(),
+		"events":         client.CoreV1(),
+		"configmaps":     client.CoreV1(),
+		"nodes":          client.CoreV1(),
+	}
+	if len(getters) != len(typedClients) {
+		t.Fatalf("got %d getters for %d typed clients", len(getters), len(typedClients))
+	}
+	for name, getter := range getters {
+		r := newResource(name, getter, metav1.NamespaceDefault)
+		list, err := r.list(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !meta.IsListType(list) {
+			t.Errorf("%s: got %T, want a list", name, list)
+		}
+		w, err := r.watch(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		w.Stop()
+	}
+}
+
+func TestGetDeploymentList(t *testing.T) {
+	client := kubetest.NewClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging"}},
+	)
+
+	list, err := GetDeploymentList(context.Background(), client.AppsV1(), WithNamespace("staging"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "api" {
+		t.Errorf("got %d deployments, want api of staging", len(list.Items))
+	}
+
+	list, err = GetDeploymentList(context.Background(), client.AppsV1(), WithAllNamespaces())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("got %d deployments of all namespaces, want 2", len(list.Items))
+	}
+}
+
+func TestGetNodeListIgnoresNamespace(t *testing.T) {
+	client := kubetest.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}})
+
+	list, err := GetNodeList(context.Background(), client.CoreV1(), WithNamespace("staging"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "node-0" {
+		t.Errorf("got %d nodes, want node-0", len(list.Items))
+	}
+}
+
+func TestGetJobWatcher(t *testing.T) {
+	client := kubetest.NewClientset()
+	script := kubetest.NewWatchScript(t, client, "jobs")
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"}}
+	go script.Add(job)
+
+	w, err := GetJobWatcher(context.Background(), client.BatchV1(), WithLabelSelector("app=web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	event := <-w.ResultChan()
+	if event.Type != watch.Added || event.Object.(*batchv1.Job).Name != "migrate" {
+		t.Errorf("got %s %v, want migrate added", event.Type, event.Object)
+	}
+	if actions := script.Actions(); len(actions) != 1 || actions[0].GetWatchRestrictions().Labels.String() != "app=web" {
+		t.Errorf("got watches %v, want one of app=web", actions)
+	}
+}
@@ -0,0 +1,296 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	watch "k8s.io/apimachinery/pkg/watch"
+	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// retryWatchBackoff spaces the watch attempts after failures.
+var retryWatchBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    10,
+	Cap:      30 * time.Second,
+}
+
+// retryPodWatcher keeps a pod watch open across server timeouts,
+// disconnects and expired resource versions.
+type retryPodWatcher struct {
+	client coreclient.PodsGetter
+	o      *KubeOptions
+
+	// pods holds the last known state of every pod by namespace/name
+	pods            map[string]*corev1.Pod
+	resourceVersion string
+
+	result   chan watch.Event
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// GetRetryPodWatcher returns a pod watcher that can be used in place of
+// GetPodWatcher but does not end when the server closes the watch. It
+// resumes from the last resource version, using bookmarks to keep that
+// version fresh, and backs off between failed attempts. When the version
+// has expired (410 Gone) it lists the pods again and emits synthetic
+// Added, Modified and Deleted events for the pods that changed meanwhile.
+//
+// Without WithResourceVersion the pods are listed first and sent as Added
+// events. With it nothing is listed and the known pods are seeded from the
+// watch, so a relist reports the pods not seen since as Added; callers
+// that hold the list of that version pass it to GetRetryPodWatcherFromList
+// instead. The result channel is closed when ctx is done or Stop is
+// called.
+func GetRetryPodWatcher(ctx context.Context, client coreclient.PodsGetter, opts ...KubeOption) (watch.Interface, error) {
+	w := newRetryPodWatcher(client, opts)
+	if w.o.ResourceVersion != "" {
+		w.resourceVersion = w.o.ResourceVersion
+		go w.run(ctx, nil)
+		return w, nil
+	}
+
+	podList, err := GetPodList(ctx, client, w.listOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	initial := []watch.Event{}
+	for i := range podList.Items {
+		initial = append(initial, watch.Event{Type: watch.Added, Object: &podList.Items[i]})
+	}
+	w.seed(podList)
+	go w.run(ctx, initial)
+	return w, nil
+}
+
+// GetRetryPodWatcherFromList is GetRetryPodWatcher for a caller that has
+// listed the pods of opts itself. The watch resumes from the version of
+// podList and its pods seed the known state without being sent again.
+func GetRetryPodWatcherFromList(ctx context.Context, client coreclient.PodsGetter, podList *corev1.PodList, opts ...KubeOption) (watch.Interface, error) {
+	w := newRetryPodWatcher(client, opts)
+	w.seed(podList)
+	go w.run(ctx, nil)
+	return w, nil
+}
+
+func newRetryPodWatcher(client coreclient.PodsGetter, opts []KubeOption) *retryPodWatcher {
+	return &retryPodWatcher{
+		client: client,
+		o:      NewKubeOptions(opts),
+		pods:   map[string]*corev1.Pod{},
+		result: make(chan watch.Event),
+		stop:   make(chan struct{}),
+	}
+}
+
+// seed takes the pods and version of podList as the last known state, so
+// that a later relist can tell what changed.
+func (w *retryPodWatcher) seed(podList *corev1.PodList) {
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		w.pods[podKey(pod)] = pod
+	}
+	w.resourceVersion = podList.ResourceVersion
+}
+
+func podKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func (w *retryPodWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *retryPodWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// listOptions returns the options of a full list, which starts at the
+// latest resource version. A limit would cut the list short and make the
+// missing pods look deleted, so it is dropped.
+func (w *retryPodWatcher) listOptions() []KubeOption {
+	return []KubeOption{
+		func(o *KubeOptions) {
+			*o = *w.o
+			o.ResourceVersion = ""
+			o.Limit = 0
+		},
+	}
+}
+
+// send passes e to the consumer and reports false once the watcher is
+// stopped.
+func (w *retryPodWatcher) send(ctx context.Context, e watch.Event) bool {
+	select {
+	case w.result <- e:
+		return true
+	case <-w.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// wait sleeps for d and reports false once the watcher is stopped.
+func (w *retryPodWatcher) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-w.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *retryPodWatcher) run(ctx context.Context, initial []watch.Event) {
+	defer close(w.result)
+	for _, e := range initial {
+		if !w.send(ctx, e) {
+			return
+		}
+	}
+
+	backoff := retryWatchBackoff
+	for {
+		expired, progressed, err := w.watchOnce(ctx)
+		select {
+		case <-w.stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if progressed {
+			backoff = retryWatchBackoff
+		}
+		if err != nil {
+			runtime.HandleError(fmt.Errorf("pod watch: %v", err))
+		}
+		if expired {
+			if err := w.relist(ctx); err != nil {
+				runtime.HandleError(fmt.Errorf("pod relist: %v", err))
+				if !w.wait(ctx, backoff.Step()) {
+					return
+				}
+			}
+			continue
+		}
+		// a watch that fails or ends without any event is retried later
+		if (err != nil || !progressed) && !w.wait(ctx, backoff.Step()) {
+			return
+		}
+	}
+}
+
+// watchOnce watches from the last resource version until the watch ends.
+// It reports whether the version has expired and whether any event was
+// received.
+func (w *retryPodWatcher) watchOnce(ctx context.Context) (bool, bool, error) {
+	o := *w.o
+	o.ResourceVersion = w.resourceVersion
+	options := o.ListOptions()
+	options.Limit = 0
+	options.AllowWatchBookmarks = true
+
+	watcher, err := w.client.Pods(o.listNamespace()).Watch(ctx, options)
+	if err != nil {
+		return apierrors.IsResourceExpired(err) || apierrors.IsGone(err), false, err
+	}
+	defer watcher.Stop()
+
+	progressed := false
+	for {
+		var e watch.Event
+		var ok bool
+		select {
+		case e, ok = <-watcher.ResultChan():
+		case <-w.stop:
+			return false, progressed, nil
+		case <-ctx.Done():
+			return false, progressed, nil
+		}
+		if !ok {
+			return false, progressed, nil
+		}
+
+		switch e.Type {
+		case watch.Error:
+			err := apierrors.FromObject(e.Object)
+			return apierrors.IsResourceExpired(err) || apierrors.IsGone(err), progressed, err
+		case watch.Bookmark:
+			if pod := GetPod(e.Object); pod != nil {
+				w.resourceVersion = pod.ResourceVersion
+			}
+			progressed = true
+			continue
+		}
+
+		pod := GetPod(e.Object)
+		if pod == nil {
+			continue
+		}
+		progressed = true
+		w.resourceVersion = pod.ResourceVersion
+		if e.Type == watch.Deleted {
+			delete(w.pods, podKey(pod))
+		} else {
+			w.pods[podKey(pod)] = pod
+		}
+		if !w.send(ctx, e) {
+			return false, progressed, nil
+		}
+	}
+}
+
+// relist lists the pods again and emits an event for every pod that was
+// added, modified or deleted since the last known state.
+func (w *retryPodWatcher) relist(ctx context.Context) error {
+	podList, err := GetPodList(ctx, w.client, w.listOptions()...)
+	if err != nil {
+		return err
+	}
+
+	events := []watch.Event{}
+	pods := map[string]*corev1.Pod{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		key := podKey(pod)
+		pods[key] = pod
+		old, ok := w.pods[key]
+		if !ok {
+			events = append(events, watch.Event{Type: watch.Added, Object: pod})
+			continue
+		}
+		if old.ResourceVersion != pod.ResourceVersion {
+			events = append(events, watch.Event{Type: watch.Modified, Object: pod})
+		}
+	}
+	for key, pod := range w.pods {
+		if _, ok := pods[key]; !ok {
+			events = append(events, watch.Event{Type: watch.Deleted, Object: pod})
+		}
+	}
+
+	w.pods = pods
+	w.resourceVersion = podList.ResourceVersion
+	for _, e := range events {
+		if !w.send(ctx, e) {
+			return nil
+		}
+	}
+	return nil
+}
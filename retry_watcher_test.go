@@ -0,0 +1,158 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/josudoey/kube/kubetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	watch "k8s.io/apimachinery/pkg/watch"
+)
+
+// nextEvent returns the next event of w or fails the test.
+func nextEvent(t *testing.T, w watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case e, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatal("watch closed")
+		}
+		return e
+	case <-time.After(kubetest.WatchTimeout):
+		t.Fatal("no event")
+	}
+	return watch.Event{}
+}
+
+// eventsByPod returns the next n events of w keyed by pod name.
+func eventsByPod(t *testing.T, w watch.Interface, n int) map[string]watch.EventType {
+	t.Helper()
+	events := map[string]watch.EventType{}
+	for i := 0; i < n; i++ {
+		e := nextEvent(t, w)
+		events[GetPod(e.Object).Name] = e.Type
+	}
+	return events
+}
+
+func TestRetryPodWatcherSendsListedPods(t *testing.T) {
+	client := kubetest.NewClientset(kubetest.NewPod("a"), kubetest.NewPod("b"))
+	kubetest.NewWatchScript(t, client, "pods")
+
+	w, err := GetRetryPodWatcher(context.Background(), client.CoreV1())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	events := eventsByPod(t, w, 2)
+	if events["a"] != watch.Added || events["b"] != watch.Added {
+		t.Errorf("got %v, want a and b added", events)
+	}
+}
+
+func TestRetryPodWatcherRelistsExpiredVersion(t *testing.T) {
+	ctx := context.Background()
+	client := kubetest.NewClientset(
+		kubetest.NewPod("a", kubetest.WithPodResourceVersion("1")),
+		kubetest.NewPod("b", kubetest.WithPodResourceVersion("2")),
+		kubetest.NewPod("c", kubetest.WithPodResourceVersion("3")),
+	)
+	script := kubetest.NewWatchScript(t, client, "pods")
+	podList, err := GetPodList(ctx, client.CoreV1())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listActions := len(client.Actions())
+
+	w, err := GetRetryPodWatcherFromList(ctx, client.CoreV1(), podList, WithLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// while the watch is down, a changes, b goes and d comes
+	pods := client.CoreV1().Pods(metav1.NamespaceDefault)
+	if _, err := pods.Update(ctx, kubetest.NewPod("a", kubetest.WithPodResourceVersion("4")), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pods.Delete(ctx, "b", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pods.Create(ctx, kubetest.NewPod("d", kubetest.WithPodResourceVersion("5")), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	script.Expire()
+
+	events := eventsByPod(t, w, 3)
+	want := map[string]watch.EventType{"a": watch.Modified, "b": watch.Deleted, "d": watch.Added}
+	for name, eventType := range want {
+		if events[name] != eventType {
+			t.Errorf("got %v, want %v", events, want)
+			break
+		}
+	}
+	// the limit of the watcher does not cut the relist short
+	if _, ok := events["c"]; ok {
+		t.Errorf("got event %s for unchanged pod c", events["c"])
+	}
+
+	lists := 0
+	for _, action := range client.Actions()[listActions:] {
+		if action.GetVerb() == "list" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("got %d lists, want only the relist", lists)
+	}
+}
+
+func TestRetryPodWatcherResumesFromBookmark(t *testing.T) {
+	client := kubetest.NewClientset()
+	script := kubetest.NewWatchScript(t, client, "pods")
+
+	w, err := GetRetryPodWatcher(context.Background(), client.CoreV1(), WithResourceVersion("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	bookmark := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "7"}}
+	script.Bookmark(bookmark).Close().Add(kubetest.NewPod("a", kubetest.WithPodResourceVersion("8")))
+	if e := nextEvent(t, w); e.Type != watch.Added || GetPod(e.Object).Name != "a" {
+		t.Fatalf("got %s %v, want a added", e.Type, e.Object)
+	}
+
+	actions := script.Actions()
+	if len(actions) != 2 {
+		t.Fatalf("got %d watches, want 2", len(actions))
+	}
+	for i, want := range []string{"1", "7"} {
+		restrictions := actions[i].GetWatchRestrictions()
+		if restrictions.ResourceVersion != want {
+			t.Errorf("watch %d: got resource version %q, want %q", i, restrictions.ResourceVersion, want)
+		}
+	}
+}
+
+func TestRetryPodWatcherStops(t *testing.T) {
+	client := kubetest.NewClientset()
+	kubetest.NewWatchScript(t, client, "pods")
+
+	w, err := GetRetryPodWatcher(context.Background(), client.CoreV1(), WithResourceVersion("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+	select {
+	case _, ok := <-w.ResultChan():
+		if ok {
+			t.Error("got an event after Stop")
+		}
+	case <-time.After(kubetest.WatchTimeout):
+		t.Error("result channel not closed after Stop")
+	}
+}
@@ -1,7 +1,9 @@
 package kube
 
 import (
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
@@ -10,15 +12,11 @@ import (
 
 var SelectorsForObject = polymorphichelpers.SelectorsForObject
 
+// GetPodSelector returns the label selector of the pods of a kubectl
+// style reference such as deploy/api, sts/db, svc/web, rs/x or job/y. See
+// GetPodSelectorFor to also accept pods.
 func GetPodSelector(f cmdutil.Factory, namespace string, resourceName string) (labels.Selector, error) {
-	builder := f.NewBuilder().
-		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
-		ContinueOnError().
-		NamespaceParam(namespace).DefaultNamespace()
-
-	builder.ResourceNames("pods", resourceName)
-
-	obj, err := builder.Do().Object()
+	obj, err := getObject(f, namespace, "pods", resourceName)
 	if err != nil {
 		return nil, err
 	}
@@ -30,3 +28,55 @@ func GetPodSelector(f cmdutil.Factory, namespace string, resourceName string) (l
 
 	return selector, nil
 }
+
+// GetPodSelectorFor returns the label and field selectors of the pods of
+// a kubectl style reference, with defaultResource as the type of bare
+// names, e.g. "pods" or "deployments". A pod is selected by its name
+// alone.
+func GetPodSelectorFor(f cmdutil.Factory, namespace string, defaultResource string, resourceName string) (labels.Selector, fields.Selector, error) {
+	obj, err := getObject(f, namespace, defaultResource, resourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the labels of a pod are shared by its siblings
+	if pod := GetPod(obj); pod != nil {
+		return labels.Everything(), fields.OneTermEqualSelector("metadata.name", pod.Name), nil
+	}
+
+	_, selector, err := SelectorsForObject(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return selector, fields.Everything(), nil
+}
+
+// getObject gets the object of a kubectl style reference, with
+// defaultResource as the type of bare names.
+func getObject(f cmdutil.Factory, namespace string, defaultResource string, resourceName string) (runtime.Object, error) {
+	builder := f.NewBuilder().
+		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
+		ContinueOnError().
+		NamespaceParam(namespace).DefaultNamespace()
+
+	builder.ResourceNames(defaultResource, resourceName)
+
+	return builder.Do().Object()
+}
+
+// MergeSelectors joins label or field selectors, so that objects have to
+// match all of them.
+func MergeSelectors(selectors ...string) string {
+	merged := ""
+	for _, selector := range selectors {
+		if selector == "" {
+			continue
+		}
+		if merged != "" {
+			merged += ","
+		}
+		merged += selector
+	}
+	return merged
+}
@@ -0,0 +1,100 @@
+package kube
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest/fake"
+	cmdtesting "k8s.io/kubectl/pkg/cmd/testing"
+)
+
+// newObjectFactory returns a factory whose API server answers every get
+// with obj.
+func newObjectFactory(t *testing.T, obj runtime.Object) *cmdtesting.TestFactory {
+	f := cmdtesting.NewTestFactory().WithNamespace(metav1.NamespaceDefault)
+	t.Cleanup(f.Cleanup)
+	codec := scheme.Codecs.LegacyCodec(scheme.Scheme.PrioritizedVersionsAllGroups()...)
+	f.Client = &fake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		Resp: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     cmdtesting.DefaultHeader(),
+			Body:       cmdtesting.ObjBody(codec, obj),
+		},
+	}
+	return f
+}
+
+func newWebDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: metav1.NamespaceDefault},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+}
+
+func TestGetPodSelector(t *testing.T) {
+	selector, err := GetPodSelector(newObjectFactory(t, newWebDeployment()), "default", "deployments/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selector.String() != "app=web" {
+		t.Errorf("got %q, want app=web", selector)
+	}
+}
+
+func TestGetPodSelectorFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		obj       runtime.Object
+		reference string
+		labels    string
+		fields    string
+	}{
+		{
+			name:      "deployment",
+			obj:       newWebDeployment(),
+			reference: "deployments/web",
+			labels:    "app=web",
+		},
+		{
+			name:      "bare deployment name",
+			obj:       newWebDeployment(),
+			reference: "web",
+			labels:    "app=web",
+		},
+		{
+			name:      "pod",
+			obj:       kubetest.NewPod("web-0", kubetest.WithPodLabels(map[string]string{"app": "web"})),
+			reference: "pods/web-0",
+			fields:    "metadata.name=web-0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, fields, err := GetPodSelectorFor(newObjectFactory(t, tt.obj), "default", "deployments", tt.reference)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels.String() != tt.labels || fields.String() != tt.fields {
+				t.Errorf("got %q and %q, want %q and %q", labels, fields, tt.labels, tt.fields)
+			}
+		})
+	}
+}
+
+func TestMergeSelectors(t *testing.T) {
+	if got := MergeSelectors("", "app=web", "", "tier!=db"); got != "app=web,tier!=db" {
+		t.Errorf("got %q, want app=web,tier!=db", got)
+	}
+	if got := MergeSelectors("", ""); got != "" {
+		t.Errorf("got %q, want an empty selector", got)
+	}
+}
@@ -4,13 +4,30 @@ import (
 	"context"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
+// GetServiceList returns the services of every page of the list in one
+// ServiceList.
 func GetServiceList(ctx context.Context, client coreclient.ServicesGetter, opts ...KubeOption) (*corev1.ServiceList, error) {
-	o := NewKubeOptions(opts)
-	options := metav1.ListOptions{LabelSelector: o.LabelSelector}
+	serviceList := &corev1.ServiceList{}
+	if err := getList(ctx, "services", client, serviceList, opts); err != nil {
+		return nil, err
+	}
+	return serviceList, nil
+}
 
-	return client.Services(o.Namespace).List(ctx, options)
+// EachService calls fn with the services of the list page by page. It
+// stops at the first error of fn.
+func EachService(ctx context.Context, client coreclient.ServicesGetter, fn func(svc *corev1.Service) error, opts ...KubeOption) error {
+	return eachItem(ctx, "services", client, opts, func(page runtime.Object) error {
+		services := page.(*corev1.ServiceList)
+		for i := range services.Items {
+			if err := fn(&services.Items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
@@ -1,26 +1,135 @@
 package vhost
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 )
 
+const (
+	// idleConnTimeout closes pooled HTTP connections to a pod so that they
+	// do not keep port-forward streams open forever.
+	idleConnTimeout = 90 * time.Second
+	// shutdownPollInterval is how often idle pooled connections are closed
+	// while a backend drains.
+	shutdownPollInterval = 100 * time.Millisecond
+	// removeDrainTimeout bounds how long the streams of a removed backend
+	// may finish before its port-forward connection is closed.
+	removeDrainTimeout = 30 * time.Second
+	// dialRetryInterval is how long a failed port-forward dial is answered
+	// from its error before the pod is dialed again.
+	dialRetryInterval = time.Second
+)
+
+// errBackendRemoved is returned when a request dials a backend that has
+// been removed meanwhile.
+var errBackendRemoved = errors.New("pod backend removed")
+
+// HostNameConflict reports a host name claimed by two service ports.
+// The port added first keeps the host name.
+type HostNameConflict struct {
+	HostName string
+	Kept     *ServicePortEntry
+	Rejected *ServicePortEntry
+}
+
+func (c HostNameConflict) Error() string {
+	return fmt.Sprintf("host name %s of svc/%s is already used by svc/%s", c.HostName, c.Rejected.SourceHostPort(), c.Kept.SourceHostPort())
+}
+
 type ServicePortEntryRouter struct {
-	m sync.Map
+	// lock serializes add and remove, lookups go to m directly
+	lock sync.Mutex
+	m    sync.Map
+
+	conflictLock sync.Mutex
+	conflicts    []HostNameConflict
 }
 
 func (p *ServicePortEntryRouter) AddIfNotExists(item *ServicePortEntry) (actual *ServicePortEntry, loaded bool) {
+	actual, loaded, _ = p.add(item)
+	return actual, loaded
+}
+
+func (p *ServicePortEntryRouter) add(item *ServicePortEntry) (actual *ServicePortEntry, loaded bool, conflicts []HostNameConflict) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	hostPort := item.SourceHostPort()
 	v, loaded := p.m.LoadOrStore(hostPort, item)
 	if !loaded {
-		hostName := item.SourceHostName()
 		p.m.Store(item, item)
-		p.m.Store(hostName, item)
+		for _, hostName := range item.SourceHostNames() {
+			v, exists := p.m.LoadOrStore(hostName, item)
+			if !exists {
+				continue
+			}
+			kept, _ := v.(*ServicePortEntry)
+			if kept == item {
+				continue
+			}
+			conflicts = append(conflicts, HostNameConflict{
+				HostName: hostName,
+				Kept:     kept,
+				Rejected: item,
+			})
+		}
+	}
+	if len(conflicts) > 0 {
+		p.conflictLock.Lock()
+		p.conflicts = append(p.conflicts, conflicts...)
+		p.conflictLock.Unlock()
 	}
 	actual, _ = v.(*ServicePortEntry)
-	return actual, loaded
+	return actual, loaded, conflicts
+}
+
+// remove drops item and the host names routed to it.
+func (p *ServicePortEntryRouter) remove(item *ServicePortEntry) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	keys := append([]string{item.SourceHostPort()}, item.SourceHostNames()...)
+	for _, key := range keys {
+		if v, ok := p.m.Load(key); ok && v == item {
+			p.m.Delete(key)
+		}
+	}
+	p.m.Delete(item)
+
+	p.conflictLock.Lock()
+	defer p.conflictLock.Unlock()
+	conflicts := []HostNameConflict{}
+	for _, conflict := range p.conflicts {
+		if conflict.Kept != item && conflict.Rejected != item {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	p.conflicts = conflicts
+}
+
+// Conflicts returns the host names that were claimed by more than one
+// service port.
+func (p *ServicePortEntryRouter) Conflicts() []HostNameConflict {
+	p.conflictLock.Lock()
+	defer p.conflictLock.Unlock()
+	return append([]HostNameConflict{}, p.conflicts...)
+}
+
+// HostNames returns the host names routed to item.
+func (p *ServicePortEntryRouter) HostNames(item *ServicePortEntry) []string {
+	names := []string{}
+	for _, hostName := range item.SourceHostNames() {
+		if p.Resolve(hostName) == item {
+			names = append(names, hostName)
+		}
+	}
+	return names
 }
 
 func (p *ServicePortEntryRouter) Range(f func(item *ServicePortEntry) bool) {
@@ -74,11 +183,24 @@ func (p *PodMap) Delete(key string) {
 	p.m.Delete(key)
 }
 
+func (p *PodMap) Range(f func(pod *corev1.Pod) bool) {
+	p.m.Range(func(k, v interface{}) bool {
+		pod, _ := v.(*corev1.Pod)
+		return f(pod)
+	})
+}
+
 type PodBackend struct {
 	matchedPod *MatchedPod
-	dialOnce   sync.Once
+	entry      *ServicePortEntry
+	dialLock   sync.Mutex
 	connection *PortForwardConnection
 	err        error
+	errUntil   time.Time
+	removed    bool
+
+	transportLock sync.Mutex
+	transport     *http.Transport
 
 	OnCreatePortForward func()
 	OnClosePortForward  func()
@@ -98,37 +220,158 @@ func (backend *PodBackend) GetTargetPort() int32 {
 	return backend.matchedPod.GetTargetPort()
 }
 
+// Transport returns the HTTP transport bound to this pod, creating it with
+// dial on first use so that pooled connections never cross pods.
+func (backend *PodBackend) Transport(dial func(ctx context.Context, network, addr string) (net.Conn, error)) http.RoundTripper {
+	backend.transportLock.Lock()
+	defer backend.transportLock.Unlock()
+	if backend.transport == nil {
+		backend.transport = &http.Transport{
+			DialContext:     dial,
+			IdleConnTimeout: idleConnTimeout,
+		}
+	}
+	return backend.transport
+}
+
+func (backend *PodBackend) closeIdleConnections() {
+	backend.transportLock.Lock()
+	defer backend.transportLock.Unlock()
+	if backend.transport != nil {
+		backend.transport.CloseIdleConnections()
+	}
+}
+
+// closeTransport closes the idle connections of the HTTP transport and
+// drops it, once the pod no longer serves.
+func (backend *PodBackend) closeTransport() {
+	backend.transportLock.Lock()
+	defer backend.transportLock.Unlock()
+	if backend.transport != nil {
+		backend.transport.CloseIdleConnections()
+		backend.transport = nil
+	}
+}
+
+// remove stops the backend once it left its set, so that nothing else
+// reaches it: no port-forward is dialed anymore, the transport is dropped
+// and the port-forward connection is shut down in the background, which
+// frees its slot of the connection cap.
+func (backend *PodBackend) remove() {
+	backend.dialLock.Lock()
+	backend.removed = true
+	backend.dialLock.Unlock()
+	backend.closeTransport()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), removeDrainTimeout)
+		defer cancel()
+		backend.Shutdown(ctx)
+	}()
+}
+
+func (backend *PodBackend) getConnection() *PortForwardConnection {
+	backend.dialLock.Lock()
+	defer backend.dialLock.Unlock()
+	return backend.connection
+}
+
 func (backend *PodBackend) Close() error {
-	if backend.connection == nil {
+	backend.closeIdleConnections()
+	connection := backend.getConnection()
+	if connection == nil {
 		return nil
 	}
-	return backend.connection.Close()
+	return connection.Close()
 }
 
-func (backend *PodBackend) DialPortForwardOnce(client rest.Interface, config *rest.Config, namespace string) (*PortForwardConnection, error) {
-	backend.dialOnce.Do(func() {
-		connection, err := DialPortForwardConnection(client, config, namespace, backend.GetName())
-		if err != nil {
-			backend.err = err
-			return
-		}
-		backend.connection = connection
-		if backend.OnCreatePortForward != nil {
-			go backend.OnCreatePortForward()
+// Shutdown drains the streams of the port-forward connection and closes it.
+// Idle pooled HTTP connections are closed while draining so that they do
+// not hold streams open.
+func (backend *PodBackend) Shutdown(ctx context.Context) error {
+	backend.closeIdleConnections()
+	connection := backend.getConnection()
+	if connection == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- connection.Shutdown(ctx)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			backend.closeIdleConnections()
 		}
-		go func() {
-			<-backend.connection.CloseChan()
-			if backend.OnClosePortForward == nil {
-				return
-			}
-			go backend.OnClosePortForward()
-		}()
-	})
+	}
+}
 
-	if backend.err != nil {
+// CloseIdle closes the port-forward connection when it has had no streams
+// for at least timeout, and reports whether it did.
+func (backend *PodBackend) CloseIdle(timeout time.Duration) bool {
+	backend.dialLock.Lock()
+	defer backend.dialLock.Unlock()
+	if backend.connection == nil {
+		return false
+	}
+	idleSince, idle := backend.connection.IdleSince()
+	if !idle || time.Since(idleSince) < timeout {
+		return false
+	}
+	backend.connection.Connection.Close()
+	backend.connection = nil
+	return true
+}
+
+// DialPortForwardOnce returns the port-forward connection of the pod,
+// dialing it on first use and again after it has been closed. A failed
+// dial is reported to the requests of the next dialRetryInterval without
+// dialing again, so that an unreachable pod is not flooded.
+func (backend *PodBackend) DialPortForwardOnce(client rest.Interface, config *rest.Config, namespace string) (*PortForwardConnection, error) {
+	backend.dialLock.Lock()
+	defer backend.dialLock.Unlock()
+	if backend.removed {
+		return nil, errBackendRemoved
+	}
+	if backend.err != nil && time.Now().Before(backend.errUntil) {
 		return nil, backend.err
 	}
-	return backend.connection, nil
+	backend.err = nil
+	if backend.connection != nil {
+		return backend.connection, nil
+	}
+
+	connection, err := DialPortForwardConnection(client, config, namespace, backend.GetName())
+	if err != nil {
+		// a full connection cap is not a problem of the pod
+		if !errors.Is(err, ErrLimitExceeded) {
+			backend.err = err
+			backend.errUntil = time.Now().Add(dialRetryInterval)
+		}
+		return nil, err
+	}
+	backend.connection = connection
+	if backend.OnCreatePortForward != nil {
+		go backend.OnCreatePortForward()
+	}
+	go func() {
+		<-connection.CloseChan()
+		backend.dialLock.Lock()
+		if backend.connection == connection {
+			backend.connection = nil
+		}
+		backend.dialLock.Unlock()
+		if backend.OnClosePortForward == nil {
+			return
+		}
+		go backend.OnClosePortForward()
+	}()
+	return connection, nil
 }
 
 func NewPodBackend(matchedPod *MatchedPod) *PodBackend {
@@ -138,7 +381,8 @@ func NewPodBackend(matchedPod *MatchedPod) *PodBackend {
 }
 
 type PodBackendSet struct {
-	m sync.Map
+	m    sync.Map
+	next uint32
 }
 
 func (p *PodBackendSet) GetOne() *PodBackend {
@@ -150,6 +394,18 @@ func (p *PodBackendSet) GetOne() *PodBackend {
 	return pod
 }
 
+func (p *PodBackendSet) GetByName(podName string) *PodBackend {
+	var pod *PodBackend
+	p.Range(func(value *PodBackend) bool {
+		if value.GetName() != podName {
+			return true
+		}
+		pod = value
+		return false
+	})
+	return pod
+}
+
 func (p *PodBackendSet) Range(f func(value *PodBackend) bool) {
 	p.m.Range(func(k, v interface{}) bool {
 		value, _ := v.(*PodBackend)
@@ -171,6 +427,7 @@ func (p *PodBackendSet) DeleteByName(podName string) {
 			return true
 		}
 		p.Delete(value)
+		value.remove()
 		return true
 	})
 }
@@ -211,6 +468,16 @@ func (p *ServiceBackend) Range(f func(key *ServicePortEntry, value *PodBackendSe
 	})
 }
 
+// Remove drops the backends of key and returns them.
+func (p *ServiceBackend) Remove(key *ServicePortEntry) []*PodBackend {
+	v, ok := p.m.LoadAndDelete(key)
+	if !ok {
+		return nil
+	}
+	set, _ := v.(*PodBackendSet)
+	return set.Values()
+}
+
 func (p *ServiceBackend) Delete(pod *PodBackend) {
 	p.Range(func(key *ServicePortEntry, value *PodBackendSet) bool {
 		value.Delete(pod)
@@ -0,0 +1,153 @@
+package vhost
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/josudoey/kube/kubetest"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeStreamConnection is a port-forward connection without streams that
+// records whether it was closed.
+type fakeStreamConnection struct {
+	closeOnce sync.Once
+	closed    chan bool
+}
+
+func newFakeStreamConnection() *fakeStreamConnection {
+	return &fakeStreamConnection{closed: make(chan bool)}
+}
+
+func (c *fakeStreamConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	return nil, errors.New("no streams")
+}
+
+func (c *fakeStreamConnection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *fakeStreamConnection) CloseChan() <-chan bool {
+	return c.closed
+}
+
+func (c *fakeStreamConnection) SetIdleTimeout(timeout time.Duration) {}
+
+func (c *fakeStreamConnection) RemoveStreams(streams ...httpstream.Stream) {}
+
+// connectBackend gives the backend of hostname an open port-forward
+// connection.
+func connectBackend(t *testing.T, resolver *PortForwardResolver, hostname string) (*PodBackend, *fakeStreamConnection) {
+	t.Helper()
+	backend := resolver.ResolveBackend(hostname)
+	if backend == nil {
+		t.Fatalf("%s: no backend", hostname)
+	}
+	conn := newFakeStreamConnection()
+	backend.connection = &PortForwardConnection{PodName: backend.GetName(), Connection: conn}
+	return backend, conn
+}
+
+func waitClosed(t *testing.T, conn *fakeStreamConnection) {
+	t.Helper()
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(kubetest.WatchTimeout):
+		t.Fatal("port-forward connection of the removed backend not closed")
+	}
+}
+
+func TestRemovedBackendClosesPortForward(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(resolver *PortForwardResolver)
+	}{
+		{
+			name: "pod deleted",
+			remove: func(resolver *PortForwardResolver) {
+				resolver.DeleteByName("web-0")
+			},
+		},
+		{
+			name: "service removed",
+			remove: func(resolver *PortForwardResolver) {
+				resolver.RemoveService(newWebService())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := NewPortForwardResolver()
+			resolver.AddService(newWebService())
+			resolver.AddPod(newWebPod("web-0", kubetest.WithPodReady()))
+			backend, conn := connectBackend(t, resolver, "web-80")
+
+			tt.remove(resolver)
+			waitClosed(t, conn)
+			if _, err := backend.DialPortForwardOnce(nil, nil, "default"); err != errBackendRemoved {
+				t.Errorf("got %v dialing a removed backend, want %v", err, errBackendRemoved)
+			}
+		})
+	}
+}
+
+// newAPIClient returns a client of an API server served by handler.
+func newAPIClient(t *testing.T, handler http.HandlerFunc) (rest.Interface, *rest.Config) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	config := &rest.Config{Host: server.URL}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.CoreV1().RESTClient(), config
+}
+
+// denyPortForward answers a port-forward the way RBAC denies it.
+func denyPortForward(w http.ResponseWriter, req *http.Request) bool {
+	if !strings.HasSuffix(req.URL.Path, "/portforward") {
+		return false
+	}
+	http.Error(w, "pods/portforward is forbidden", http.StatusForbidden)
+	return true
+}
+
+func TestDialPortForwardOnceRetriesAfterError(t *testing.T) {
+	var dials int32
+	client, config := newAPIClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if denyPortForward(w, req) {
+			atomic.AddInt32(&dials, 1)
+		}
+	})
+	backend := NewPodBackend(&MatchedPod{Pod: *kubetest.NewPod("web-0")})
+
+	for i := 0; i < 2; i++ {
+		if _, err := backend.DialPortForwardOnce(client, config, "default"); err == nil {
+			t.Fatal("got no error from a denied port-forward")
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Errorf("got %d dials right after a failure, want 1", n)
+	}
+
+	// the pod is dialed again once the error is old enough
+	backend.errUntil = time.Now()
+	if _, err := backend.DialPortForwardOnce(client, config, "default"); err == nil {
+		t.Fatal("got no error from a denied port-forward")
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Errorf("got %d dials after the retry interval, want 2", n)
+	}
+}
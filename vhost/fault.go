@@ -0,0 +1,209 @@
+package vhost
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrFaultReset is returned by reads and writes after an injected reset.
+var ErrFaultReset = errors.New("connection reset by fault injection")
+
+// Fault describes a failure injected into the requests of a vhost whose
+// path starts with PathPrefix. Percentage is the share of requests
+// affected, all of them when unset. For gRPC the fault is decided by the
+// first request of a connection and applies to the whole connection.
+type Fault struct {
+	PathPrefix string   `json:"pathPrefix,omitempty"`
+	Percentage *float64 `json:"percentage,omitempty"`
+
+	// Delay holds requests for Delay plus a random duration up to
+	// DelayJitter before they are forwarded.
+	Delay       metav1.Duration `json:"delay,omitempty"`
+	DelayJitter metav1.Duration `json:"delayJitter,omitempty"`
+
+	// AbortStatus answers HTTP requests with the status instead of
+	// forwarding them. AbortGRPCCode does the same for gRPC.
+	AbortStatus   int         `json:"abortStatus,omitempty"`
+	AbortGRPCCode *codes.Code `json:"abortGRPCCode,omitempty"`
+
+	// ResetAfterBytes and ResetAfter cut the connection to the client once
+	// that many response bytes were sent or that much time has passed.
+	ResetAfterBytes int64           `json:"resetAfterBytes,omitempty"`
+	ResetAfter      metav1.Duration `json:"resetAfter,omitempty"`
+}
+
+func (f *Fault) Matches(path string) bool {
+	return strings.HasPrefix(path, f.PathPrefix)
+}
+
+// Hit reports whether a request is affected according to Percentage.
+func (f *Fault) Hit() bool {
+	if f.Percentage == nil || *f.Percentage >= 100 {
+		return true
+	}
+	return rand.Float64()*100 < *f.Percentage
+}
+
+func (f *Fault) DelayDuration() time.Duration {
+	d := f.Delay.Duration
+	if jitter := f.DelayJitter.Duration; jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return d
+}
+
+func (f *Fault) Resets() bool {
+	return f.ResetAfterBytes > 0 || f.ResetAfter.Duration > 0
+}
+
+// SelectFault returns the first fault of faults that matches path and
+// hits, or nil.
+func SelectFault(faults []Fault, path string) *Fault {
+	for i := range faults {
+		f := &faults[i]
+		if f.Matches(path) && f.Hit() {
+			return f
+		}
+	}
+	return nil
+}
+
+// faultReset closes a connection or body once a byte budget is spent or a
+// timer fires.
+type faultReset struct {
+	lock   sync.Mutex
+	remain int64
+	spent  bool
+	reset  bool
+	timer  *time.Timer
+	close  func() error
+}
+
+func newFaultReset(afterBytes int64, after time.Duration, close func() error) *faultReset {
+	r := &faultReset{
+		remain: afterBytes,
+		close:  close,
+	}
+	if after > 0 {
+		r.timer = time.AfterFunc(after, func() {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			r.fire()
+		})
+	}
+	return r
+}
+
+func (r *faultReset) fire() {
+	if r.reset {
+		return
+	}
+	r.reset = true
+	r.close()
+}
+
+// take returns how many of n bytes may pass, and false when the reset
+// has to follow them.
+func (r *faultReset) take(n int) (int, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.reset || r.spent {
+		return 0, false
+	}
+	if r.remain <= 0 {
+		return n, true
+	}
+	if int64(n) < r.remain {
+		r.remain -= int64(n)
+		return n, true
+	}
+	r.spent = true
+	return int(r.remain), false
+}
+
+// resetNow resets once the bytes that take let pass are through.
+func (r *faultReset) resetNow() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fire()
+}
+
+func (r *faultReset) stop() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
+
+type resetConn struct {
+	net.Conn
+	reset *faultReset
+}
+
+// NewResetConn returns conn closed abruptly after afterBytes bytes were
+// written to it or after the duration, whichever comes first. Zero
+// disables either limit.
+func NewResetConn(conn net.Conn, afterBytes int64, after time.Duration) net.Conn {
+	return &resetConn{
+		Conn:  conn,
+		reset: newFaultReset(afterBytes, after, conn.Close),
+	}
+}
+
+func (c *resetConn) Write(p []byte) (int, error) {
+	allowed, ok := c.reset.take(len(p))
+	n := 0
+	var err error
+	if allowed > 0 {
+		n, err = c.Conn.Write(p[:allowed])
+	}
+	if !ok {
+		c.reset.resetNow()
+		if err == nil {
+			err = ErrFaultReset
+		}
+	}
+	return n, err
+}
+
+func (c *resetConn) Close() error {
+	c.reset.stop()
+	return c.Conn.Close()
+}
+
+type resetBody struct {
+	io.ReadCloser
+	reset *faultReset
+}
+
+// NewResetBody returns body failing with ErrFaultReset after afterBytes
+// bytes were read or after the duration. A reverse proxy then aborts the
+// response and drops the client connection.
+func NewResetBody(body io.ReadCloser, afterBytes int64, after time.Duration) io.ReadCloser {
+	return &resetBody{
+		ReadCloser: body,
+		reset:      newFaultReset(afterBytes, after, body.Close),
+	}
+}
+
+func (b *resetBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	allowed, ok := b.reset.take(n)
+	if !ok {
+		b.reset.resetNow()
+		return allowed, ErrFaultReset
+	}
+	return n, err
+}
+
+func (b *resetBody) Close() error {
+	b.reset.stop()
+	return b.ReadCloser.Close()
+}
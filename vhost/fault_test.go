@@ -0,0 +1,86 @@
+package vhost
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func percentage(p float64) *float64 {
+	return &p
+}
+
+func TestSelectFault(t *testing.T) {
+	faults := []Fault{
+		{PathPrefix: "/never", Percentage: percentage(0), AbortStatus: 500},
+		{PathPrefix: "/api/", AbortStatus: 503},
+		{AbortStatus: 502},
+	}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/api/users", want: 503},
+		{path: "/never", want: 502},
+		{path: "/", want: 502},
+	}
+	for _, tt := range tests {
+		fault := SelectFault(faults, tt.path)
+		if fault == nil || fault.AbortStatus != tt.want {
+			t.Errorf("%s: got %+v, want the fault aborting with %d", tt.path, fault, tt.want)
+		}
+	}
+	if fault := SelectFault(faults[:2], "/other"); fault != nil {
+		t.Errorf("got %+v for a path no fault matches", fault)
+	}
+}
+
+func TestResetBody(t *testing.T) {
+	body := NewResetBody(ioutil.NopCloser(strings.NewReader("0123456789")), 4, 0)
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != ErrFaultReset || string(data) != "0123" {
+		t.Errorf("got %q and %v, want 0123 and %v", data, err, ErrFaultReset)
+	}
+}
+
+func TestResetConn(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := NewResetConn(local, 4, 0)
+	defer conn.Close()
+
+	go ioutil.ReadAll(remote)
+	if n, err := conn.Write([]byte("012")); n != 3 || err != nil {
+		t.Fatalf("got %d and %v, want the write within the budget to pass", n, err)
+	}
+	if n, err := conn.Write([]byte("3456")); n != 1 || err != ErrFaultReset {
+		t.Errorf("got %d and %v, want 1 and %v", n, err, ErrFaultReset)
+	}
+	if _, err := conn.Write([]byte("7")); err != ErrFaultReset {
+		t.Errorf("got %v after the reset, want %v", err, ErrFaultReset)
+	}
+}
+
+func TestResetConnAfter(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := NewResetConn(local, 0, time.Millisecond)
+	defer conn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := remote.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("got a read, want the connection closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not reset after the duration")
+	}
+}
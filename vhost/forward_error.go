@@ -0,0 +1,63 @@
+package vhost
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// errorStreamTimeout bounds the wait for the kubelet error stream once the
+// data stream of a forwarded port has ended without any data.
+const errorStreamTimeout = time.Second
+
+// PortForwardError is an error the kubelet reported on the error stream of
+// a forwarded port, e.g. a connection refused inside the pod.
+type PortForwardError struct {
+	Pod     string
+	Port    uint16
+	Message string
+}
+
+func (e *PortForwardError) Error() string {
+	return fmt.Sprintf("pod %s port %d: %s", e.Pod, e.Port, e.Message)
+}
+
+// statusConn keeps the local connection open when Forward returns and
+// records whether the pod wrote anything to it, so that an error status
+// can still be sent to the client.
+type statusConn struct {
+	net.Conn
+	written int32
+}
+
+func (c *statusConn) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		atomic.StoreInt32(&c.written, 1)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *statusConn) Close() error {
+	return nil
+}
+
+// Written reports whether any byte of the pod reached the client.
+func (c *statusConn) Written() bool {
+	return atomic.LoadInt32(&c.written) == 1
+}
+
+// writeHTTPError writes a 502 response for err to conn.
+func writeHTTPError(conn net.Conn, err error) error {
+	conn.SetWriteDeadline(time.Now().Add(errorStreamTimeout))
+	body := err.Error() + "\n"
+	header := fmt.Sprintf("HTTP/1.1 %d %s\r\n", http.StatusBadGateway, http.StatusText(http.StatusBadGateway)) +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"X-Content-Type-Options: nosniff\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"Connection: close\r\n\r\n"
+	_, err = conn.Write([]byte(header + body))
+	return err
+}
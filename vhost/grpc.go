@@ -8,12 +8,15 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/rest"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc/codes"
 )
 
 const (
@@ -42,6 +45,7 @@ func (r *readRecorder) Read(p []byte) (int, error) {
 }
 
 type GRPCPreface struct {
+	StreamID      uint32
 	Header        []hpack.HeaderField
 	ClientPreface []byte
 }
@@ -111,60 +115,97 @@ func GetGRPCPreface(conn net.Conn, rw *bufio.ReadWriter) (*GRPCPreface, error) {
 	}
 
 	return &GRPCPreface{
+		StreamID:      metaHeader.StreamID,
 		Header:        metaHeader.Fields,
 		ClientPreface: r.Bytes(),
 	}, nil
 }
 
-type grpcServer struct {
-	serveHTTP func(rw http.ResponseWriter, req *http.Request)
-}
-
-func (s *grpcServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	s.serveHTTP(rw, req)
-}
-
-func (resolver *PortForwardResolver) GetGRPCHandler(base http.Handler, client rest.Interface, config *rest.Config, namespace string) http.Handler {
-	handleConnection := func(local net.Conn, preface *GRPCPreface) error {
-		authority := ""
-		// see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md#requests
-		for _, f := range preface.Header {
-			if f.Name != ":authority" {
-				continue
-			}
-			authority = f.Value
+// Authority returns the :authority pseudo header of the first request.
+func (preface *GRPCPreface) Authority() string {
+	authority := ""
+	// see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md#requests
+	for _, f := range preface.Header {
+		if f.Name != ":authority" {
+			continue
 		}
+		authority = f.Value
+	}
+	return authority
+}
 
-		host, _, err := net.SplitHostPort(authority)
-		if err != nil {
-			host = authority
+// Path returns the :path pseudo header of the first request.
+func (preface *GRPCPreface) Path() string {
+	path := ""
+	for _, f := range preface.Header {
+		if f.Name != ":path" {
+			continue
 		}
+		path = f.Value
+	}
+	return path
+}
 
-		backend := resolver.ResolveBackend(host)
-		if backend == nil {
-			err := fmt.Errorf("%s svc not found", host)
-			runtime.HandleError(err)
+// WriteGRPCStatus answers the first request of a hijacked gRPC connection
+// with a trailers-only response carrying code and message, then tells the
+// client to open a new connection for its other requests.
+// see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md#responses
+func WriteGRPCStatus(conn net.Conn, preface *GRPCPreface, code codes.Code, message string) error {
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	fields := []hpack.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "application/grpc"},
+		{Name: "grpc-status", Value: strconv.Itoa(int(code))},
+		{Name: "grpc-message", Value: encodeGRPCMessage(message)},
+	}
+	for _, f := range fields {
+		if err := encoder.WriteField(f); err != nil {
 			return err
 		}
+	}
 
-		conn, err := backend.DialPortForwardOnce(client, config, namespace)
-		if err != nil {
-			resolver.DeleteByName(backend.GetName())
-			return err
+	framer := http2.NewFramer(conn, nil)
+	err := framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      preface.StreamID,
+		BlockFragment: block.Bytes(),
+		EndStream:     true,
+		EndHeaders:    true,
+	})
+	if err != nil {
+		return err
+	}
+	return framer.WriteGoAway(preface.StreamID, http2.ErrCodeNo, nil)
+}
+
+// encodeGRPCMessage percent encodes the bytes of msg that are not
+// printable ASCII.
+// see https://github.com/grpc/grpc-go/blob/v1.44.0/internal/transport/http_util.go#L245
+func encodeGRPCMessage(msg string) string {
+	var buf strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			buf.WriteByte(c)
+			continue
 		}
-		conn.OnCreateStream = backend.OnCreateStream
-		conn.OnCloseStream = backend.OnCloseStream
-
-		go func() {
-			defer local.Close()
-			err := conn.Forward(local, uint16(backend.GetTargetPort()), preface.ClientPreface)
-			if err != nil {
-				resolver.DeleteByName(backend.GetName())
-			}
-		}()
-		return nil
+		fmt.Fprintf(&buf, "%%%02X", c)
 	}
-	handler := &grpcServer{
+	return buf.String()
+}
+
+type grpcServer struct {
+	serveHTTP func(rw http.ResponseWriter, req *http.Request)
+}
+
+func (s *grpcServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.serveHTTP(rw, req)
+}
+
+// NewGRPCHandler hijacks HTTP/2 connections, reads their gRPC preface and
+// passes them to handleConnection. Other requests are served by base.
+func NewGRPCHandler(base http.Handler, handleConnection func(local net.Conn, preface *GRPCPreface) error) http.Handler {
+	return &grpcServer{
 		serveHTTP: func(res http.ResponseWriter, req *http.Request) {
 			if req.ProtoMajor == 2 {
 				h, ok := res.(http.Hijacker)
@@ -180,11 +221,78 @@ func (resolver *PortForwardResolver) GetGRPCHandler(base http.Handler, client re
 				if err != nil {
 					return
 				}
-				handleConnection(conn, preface)
+				// the reader may hold frames sent right after the preface
+				conn = &bufferedConn{Conn: conn, r: rw.Reader}
+				if err := handleConnection(conn, preface); err != nil {
+					conn.Close()
+				}
 				return
 			}
 			base.ServeHTTP(res, req)
 		},
 	}
-	return handler
+}
+
+// ForwardGRPC forwards a hijacked gRPC connection to a pod of the service
+// served under hostname, or to its local target when it is intercepted.
+func (resolver *PortForwardResolver) ForwardGRPC(local net.Conn, preface *GRPCPreface, hostname string, client rest.Interface, config *rest.Config, namespace string) error {
+	if target, ok := resolver.InterceptTarget(hostname); ok {
+		return forwardIntercept(local, preface, target)
+	}
+
+	backend := resolver.ResolveBackendFor(hostname, local.RemoteAddr().String())
+	if backend == nil {
+		err := fmt.Errorf("%s svc not found", hostname)
+		runtime.HandleError(err)
+		return err
+	}
+
+	release, err := resolver.acquireBackend(backend)
+	if err != nil {
+		WriteGRPCStatus(local, preface, codes.ResourceExhausted, err.Error())
+		return err
+	}
+
+	conn, err := backend.DialPortForwardOnce(client, config, namespace)
+	if err != nil {
+		release()
+		if errors.Is(err, ErrLimitExceeded) {
+			WriteGRPCStatus(local, preface, codes.ResourceExhausted, err.Error())
+			return err
+		}
+		resolver.DeleteByName(backend.GetName())
+		return err
+	}
+	conn.OnCreateStream = backend.OnCreateStream
+	conn.OnCloseStream = backend.OnCloseStream
+
+	go func() {
+		defer release()
+		defer local.Close()
+		status := &statusConn{Conn: local}
+		err := conn.Forward(status, uint16(backend.GetTargetPort()), preface.ClientPreface)
+		var forwardErr *PortForwardError
+		if errors.As(err, &forwardErr) {
+			runtime.HandleError(err)
+			if !status.Written() {
+				WriteGRPCStatus(local, preface, codes.Unavailable, err.Error())
+			}
+			return
+		}
+		if err != nil {
+			resolver.DeleteByName(backend.GetName())
+		}
+	}()
+	return nil
+}
+
+func (resolver *PortForwardResolver) GetGRPCHandler(base http.Handler, client rest.Interface, config *rest.Config, namespace string) http.Handler {
+	return NewGRPCHandler(base, func(local net.Conn, preface *GRPCPreface) error {
+		authority := preface.Authority()
+		host, _, err := net.SplitHostPort(authority)
+		if err != nil {
+			host = authority
+		}
+		return resolver.ForwardGRPC(local, preface, host, client, config, namespace)
+	})
 }
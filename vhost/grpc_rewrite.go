@@ -0,0 +1,157 @@
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// http2MaxReadFrameLen is the largest frame the header rewriter accepts
+// from a client, the maximum allowed by HTTP/2.
+const http2MaxReadFrameLen = 1<<24 - 1
+
+// bufferedConn reads the bytes left in the hijacked reader before reading
+// from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// grpcHeaderRewriteConn decodes every header block the client sends and
+// encodes it again after rewriting, so that the HPACK state of the server
+// follows our encoder instead of the client's.
+type grpcHeaderRewriteConn struct {
+	net.Conn
+	rewrite func(fields []hpack.HeaderField) []hpack.HeaderField
+
+	framer      *http2.Framer
+	out         bytes.Buffer
+	writer      *http2.Framer
+	headerBlock bytes.Buffer
+	encoder     *hpack.Encoder
+}
+
+// NewGRPCHeaderRewriteConn returns a connection whose reads yield the
+// client frames of conn with every header block passed through rewrite,
+// and the preface to forward in front of them.
+func NewGRPCHeaderRewriteConn(conn net.Conn, preface *GRPCPreface, rewrite func(fields []hpack.HeaderField) []hpack.HeaderField) (net.Conn, *GRPCPreface) {
+	frames := bytes.NewReader(preface.ClientPreface[len(http2.ClientPreface):])
+	c := &grpcHeaderRewriteConn{
+		Conn:    conn,
+		rewrite: rewrite,
+	}
+	c.framer = http2.NewFramer(nil, &prefixReader{prefix: frames, conn: conn})
+	c.framer.SetMaxReadFrameSize(http2MaxReadFrameLen)
+	c.framer.MaxHeaderListSize = defaultServerMaxHeaderListSize
+	c.framer.ReadMetaHeaders = hpack.NewDecoder(http2InitHeaderTableSize, nil)
+	c.writer = http2.NewFramer(&c.out, nil)
+	c.encoder = hpack.NewEncoder(&c.headerBlock)
+
+	return c, &GRPCPreface{
+		Header:        rewrite(preface.Header),
+		ClientPreface: []byte(http2.ClientPreface),
+	}
+}
+
+type prefixReader struct {
+	prefix *bytes.Reader
+	conn   net.Conn
+}
+
+func (r *prefixReader) Read(p []byte) (int, error) {
+	if r.prefix.Len() > 0 {
+		return r.prefix.Read(p)
+	}
+	return r.conn.Read(p)
+}
+
+func (c *grpcHeaderRewriteConn) Read(p []byte) (int, error) {
+	for c.out.Len() == 0 {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	return c.out.Read(p)
+}
+
+func (c *grpcHeaderRewriteConn) next() error {
+	frame, err := c.framer.ReadFrame()
+	if err != nil {
+		return err
+	}
+
+	switch f := frame.(type) {
+	case *http2.MetaHeadersFrame:
+		return c.writeHeaders(f)
+	case *http2.DataFrame:
+		return c.writer.WriteData(f.StreamID, f.StreamEnded(), f.Data())
+	case *http2.SettingsFrame:
+		if f.IsAck() {
+			return c.writer.WriteSettingsAck()
+		}
+		settings := []http2.Setting{}
+		f.ForeachSetting(func(s http2.Setting) error {
+			settings = append(settings, s)
+			return nil
+		})
+		return c.writer.WriteSettings(settings...)
+	case *http2.PingFrame:
+		return c.writer.WritePing(f.IsAck(), f.Data)
+	case *http2.WindowUpdateFrame:
+		return c.writer.WriteWindowUpdate(f.StreamID, f.Increment)
+	case *http2.RSTStreamFrame:
+		return c.writer.WriteRSTStream(f.StreamID, f.ErrCode)
+	case *http2.GoAwayFrame:
+		return c.writer.WriteGoAway(f.LastStreamID, f.ErrCode, f.DebugData())
+	case *http2.PriorityFrame:
+		return c.writer.WritePriority(f.StreamID, f.PriorityParam)
+	case *http2.UnknownFrame:
+		return c.writer.WriteRawFrame(f.Type, f.Flags, f.StreamID, f.Payload())
+	}
+	return ErrInvaidGRPCPreface
+}
+
+func (c *grpcHeaderRewriteConn) writeHeaders(f *http2.MetaHeadersFrame) error {
+	c.headerBlock.Reset()
+	for _, field := range c.rewrite(f.Fields) {
+		if err := c.encoder.WriteField(field); err != nil {
+			return err
+		}
+	}
+
+	block := c.headerBlock.Bytes()
+	first := true
+	for first || len(block) > 0 {
+		chunk := block
+		if len(chunk) > http2MaxFrameLen {
+			chunk = chunk[:http2MaxFrameLen]
+		}
+		block = block[len(chunk):]
+		endHeaders := len(block) == 0
+
+		var err error
+		if first {
+			err = c.writer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      f.StreamID,
+				BlockFragment: chunk,
+				EndStream:     f.StreamEnded(),
+				EndHeaders:    endHeaders,
+				Priority:      f.Priority,
+			})
+		} else {
+			err = c.writer.WriteContinuation(f.StreamID, endHeaders, chunk)
+		}
+		if err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Package har records HTTP exchanges in the HTTP Archive format and
+// replays them.
+// see http://www.softwareishard.com/blog/har-12-spec/
+package har
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	Version     = "1.2"
+	CreatorName = "kube-vhost"
+	// Ext is the file extension of the archives, one per vhost.
+	Ext = ".har"
+
+	// archiveTail closes the entries and the log of an archive written by
+	// appendEntries.
+	archiveTail = "\n]}}\n"
+	// tailSize is how many bytes at the end of an archive are searched
+	// for the end of its entries.
+	tailSize = 4096
+)
+
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	Comment         string    `json:"comment,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func New() *HAR {
+	return &HAR{
+		Log: Log{
+			Version: Version,
+			Creator: Creator{
+				Name:    CreatorName,
+				Version: Version,
+			},
+			Entries: []Entry{},
+		},
+	}
+}
+
+func Load(path string) (*HAR, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &HAR{}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// LoadDir loads every archive in dir, keyed by vhost name.
+func LoadDir(dir string) (map[string]*HAR, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return nil, err
+	}
+	archives := map[string]*HAR{}
+	for _, path := range paths {
+		h, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		archives[strings.TrimSuffix(filepath.Base(path), Ext)] = h
+	}
+	return archives, nil
+}
+
+// Save writes the archive to a temporary file and renames it to path, so
+// that readers never see a partial archive.
+func (h *HAR) Save(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// appendEntries adds entries to the archive at path, creating it if
+// needed. The entries already in the archive are neither read nor written
+// again: the new ones overwrite the end of the archive from the ] closing
+// its entries, the last field of the archive.
+func appendEntries(path string, entries []Entry) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	offset := int64(0)
+	first := true
+	if info.Size() == 0 {
+		head, err := json.Marshal(New())
+		if err != nil {
+			return err
+		}
+		buf.Write(head[:bytes.LastIndexByte(head, ']')])
+	} else {
+		var empty bool
+		offset, empty, err = entriesEnd(f, info.Size())
+		if err != nil {
+			return err
+		}
+		first = empty
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		buf.WriteString("\n")
+		buf.Write(data)
+	}
+	buf.WriteString(archiveTail)
+
+	if _, err := f.WriteAt(buf.Bytes(), offset); err != nil {
+		return err
+	}
+	if err := f.Truncate(offset + int64(buf.Len())); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// entriesEnd returns the offset of the ] closing the entries of the
+// archive f of size bytes and whether the archive has no entries.
+func entriesEnd(f *os.File, size int64) (int64, bool, error) {
+	n := int64(tailSize)
+	if size < n {
+		n = size
+	}
+	tail := make([]byte, n)
+	if _, err := f.ReadAt(tail, size-n); err != nil {
+		return 0, false, err
+	}
+	i := bytes.LastIndexByte(tail, ']')
+	if i < 0 {
+		return 0, false, fmt.Errorf("%s: no entries found at the end of the archive", f.Name())
+	}
+	before := bytes.TrimRight(tail[:i], " \t\r\n")
+	empty := len(before) > 0 && before[len(before)-1] == '['
+	return size - n + int64(i), empty, nil
+}
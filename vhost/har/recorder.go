@@ -0,0 +1,399 @@
+package har
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// DefaultMaxBodySize is how many bytes of a request or response body
+	// are recorded.
+	DefaultMaxBodySize = 1 << 20
+	// Redacted replaces the values of redacted headers.
+	Redacted = "REDACTED"
+	// DefaultFlushInterval is how often the archives with new entries are
+	// written.
+	DefaultFlushInterval = time.Second
+
+	// recordQueueSize is the number of entries waiting to be added before
+	// new ones are dropped.
+	recordQueueSize = 1024
+	// maxPendingEntries is the number of entries of a vhost kept until
+	// they are written. More are dropped while writing fails.
+	maxPendingEntries = 10000
+)
+
+// ErrRecorderClosed is returned by Add once the recorder is closed.
+var ErrRecorderClosed = errors.New("recorder closed")
+
+// DefaultRedactHeaders are the headers whose values are not recorded.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Recorder appends the exchanges of each vhost to <Dir>/<vhost>.har.
+// Entries are added in the background and appended to the archives every
+// FlushInterval and on Close, so that recording never holds up a proxied
+// response. Only the entries not written yet are kept in memory.
+type Recorder struct {
+	Dir           string
+	MaxBodySize   int64
+	RedactHeaders []string
+	FlushInterval time.Duration
+
+	lock    sync.Mutex
+	pending map[string][]Entry
+
+	queue     chan namedEntry
+	startOnce sync.Once
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	closeErr  error
+}
+
+type namedEntry struct {
+	name  string
+	entry Entry
+}
+
+// NewRecorder creates dir if needed. Entries are appended to the archives
+// already in it.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		Dir:           dir,
+		MaxBodySize:   DefaultMaxBodySize,
+		RedactHeaders: DefaultRedactHeaders,
+		FlushInterval: DefaultFlushInterval,
+		pending:       map[string][]Entry{},
+		queue:         make(chan namedEntry, recordQueueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Add queues entry for the archive of name without waiting for it to be
+// written. It fails when the queue is full or the recorder is closed.
+func (r *Recorder) Add(name string, entry Entry) error {
+	r.start()
+	select {
+	case <-r.stop:
+		return ErrRecorderClosed
+	default:
+	}
+	select {
+	case r.queue <- namedEntry{name: name, entry: entry}:
+		return nil
+	default:
+		return fmt.Errorf("recording queue full, dropping entry of %s", name)
+	}
+}
+
+func (r *Recorder) start() {
+	r.startOnce.Do(func() {
+		go r.run()
+	})
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	interval := r.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-r.queue:
+			r.append(e)
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				runtime.HandleError(err)
+			}
+		case <-r.stop:
+			r.drain()
+			r.closeErr = r.Flush()
+			return
+		}
+	}
+}
+
+// drain adds the entries left in the queue.
+func (r *Recorder) drain() {
+	for {
+		select {
+		case e := <-r.queue:
+			r.append(e)
+		default:
+			return
+		}
+	}
+}
+
+func (r *Recorder) append(e namedEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.pending[e.name]) >= maxPendingEntries {
+		runtime.HandleError(fmt.Errorf("error recording %s: %d entries not written yet, dropping entry", e.name, maxPendingEntries))
+		return
+	}
+	r.pending[e.name] = append(r.pending[e.name], e.entry)
+}
+
+// Flush appends the entries added since the last flush to their archives.
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, entries := range r.pending {
+		if err := appendEntries(filepath.Join(r.Dir, name+Ext), entries); err != nil {
+			return fmt.Errorf("error recording %s: %v", name, err)
+		}
+		delete(r.pending, name)
+	}
+	return nil
+}
+
+// Close adds the queued entries, writes the archives and stops recording.
+func (r *Recorder) Close() error {
+	r.closeOnce.Do(func() {
+		r.start()
+		close(r.stop)
+		<-r.done
+	})
+	return r.closeErr
+}
+
+// Transport returns a round tripper that sends requests with next and
+// records them under the vhost name once the response body is read.
+func (r *Recorder) Transport(name string, next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{
+		recorder: r,
+		name:     name,
+		next:     next,
+	}
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	name     string
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	reqBody := &limitedBuffer{limit: t.recorder.MaxBodySize}
+	out := req
+	if req.Body != nil && req.Body != http.NoBody {
+		out = new(http.Request)
+		*out = *req
+		out.Body = &recordingBody{ReadCloser: req.Body, buf: reqBody}
+	}
+
+	res, err := t.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	// the transport may write the request body until it returns
+	reqBody = reqBody.snapshot()
+	// upgraded connections are not HTTP exchanges
+	if res.StatusCode == http.StatusSwitchingProtocols {
+		return res, nil
+	}
+
+	wait := time.Now()
+	resBody := &limitedBuffer{limit: t.recorder.MaxBodySize}
+	res.Body = &recordingBody{
+		ReadCloser: res.Body,
+		buf:        resBody,
+		done: func() {
+			entry := t.recorder.entry(t.name, req, reqBody, res, resBody, start, wait, time.Now())
+			if err := t.recorder.Add(t.name, entry); err != nil {
+				runtime.HandleError(fmt.Errorf("error recording %s: %v", t.name, err))
+			}
+		},
+	}
+	return res, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *Recorder) entry(name string, req *http.Request, reqBody *limitedBuffer, res *http.Response, resBody *limitedBuffer, start, wait, end time.Time) Entry {
+	u := *req.URL
+	u.Scheme = "http"
+	u.Host = name
+
+	query := []NameValue{}
+	for key, values := range u.Query() {
+		for _, value := range values {
+			query = append(query, NameValue{Name: key, Value: value})
+		}
+	}
+
+	request := Request{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     []Cookie{},
+		Headers:     r.headers(req.Header),
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    reqBody.total,
+	}
+	if reqBody.total > 0 {
+		text, encoding, comment := reqBody.content()
+		request.PostData = &PostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		}
+	}
+
+	text, encoding, comment := resBody.content()
+	response := Response{
+		Status:      res.StatusCode,
+		StatusText:  http.StatusText(res.StatusCode),
+		HTTPVersion: res.Proto,
+		Cookies:     []Cookie{},
+		Headers:     r.headers(res.Header),
+		Content: Content{
+			Size:     resBody.total,
+			MimeType: res.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		},
+		RedirectURL: res.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    resBody.total,
+	}
+
+	return Entry{
+		StartedDateTime: start,
+		Time:            milliseconds(end.Sub(start)),
+		Request:         request,
+		Response:        response,
+		Timings: Timings{
+			Send:    0,
+			Wait:    milliseconds(wait.Sub(start)),
+			Receive: milliseconds(end.Sub(wait)),
+		},
+	}
+}
+
+func (r *Recorder) headers(header http.Header) []NameValue {
+	redact := map[string]bool{}
+	for _, name := range r.RedactHeaders {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	names := []string{}
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []NameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			if redact[http.CanonicalHeaderKey(name)] {
+				value = Redacted
+			}
+			headers = append(headers, NameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// limitedBuffer keeps the first limit bytes written to it and counts the
+// rest.
+type limitedBuffer struct {
+	lock  sync.Mutex
+	limit int64
+	total int64
+	buf   strings.Builder
+}
+
+// snapshot returns a copy of b as written so far.
+func (b *limitedBuffer) snapshot() *limitedBuffer {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c := &limitedBuffer{limit: b.limit, total: b.total}
+	c.buf.WriteString(b.buf.String())
+	return c
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.total += int64(len(p))
+	if remain := b.limit - int64(b.buf.Len()); remain > 0 {
+		if int64(len(p)) > remain {
+			b.buf.Write(p[:remain])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// content returns the recorded body as text, base64 encoded when it is
+// not valid UTF-8, with a comment when it was truncated.
+func (b *limitedBuffer) content() (text string, encoding string, comment string) {
+	text = b.buf.String()
+	if !utf8.ValidString(text) {
+		text = base64.StdEncoding.EncodeToString([]byte(text))
+		encoding = "base64"
+	}
+	if b.total > int64(b.buf.Len()) {
+		comment = fmt.Sprintf("truncated to %d of %d bytes", b.buf.Len(), b.total)
+	}
+	return text, encoding, comment
+}
+
+// recordingBody copies what is read from the body to buf and calls done
+// once at EOF or on Close.
+type recordingBody struct {
+	io.ReadCloser
+	buf  *limitedBuffer
+	once sync.Once
+	done func()
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		if b.done != nil {
+			b.done()
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package har
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// record sends a request for path through a recorder of dir and closes
+// the recorder.
+func record(t *testing.T, dir string, path string) {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte("hello " + req.URL.Path))
+	}))
+	defer backend.Close()
+
+	recorder, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: recorder.Transport("web-80", http.DefaultTransport)}
+	res, err := client.Get(backend.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecorderAppends(t *testing.T) {
+	dir := t.TempDir()
+	record(t, dir, "/a")
+	record(t, dir, "/b")
+
+	h, err := Load(filepath.Join(dir, "web-80"+Ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Log.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(h.Log.Entries))
+	}
+	for i, path := range []string{"/a", "/b"} {
+		entry := h.Log.Entries[i]
+		if entry.Request.URL != "http://web-80"+path || entry.Response.Content.Text != "hello "+path {
+			t.Errorf("entry %d: got %s %q, want %s", i, entry.Request.URL, entry.Response.Content.Text, path)
+		}
+		for _, header := range entry.Response.Headers {
+			if header.Name == "Set-Cookie" && header.Value != Redacted {
+				t.Errorf("entry %d: got Set-Cookie %q, want it redacted", i, header.Value)
+			}
+		}
+	}
+}
+
+func TestAppendEntriesToSavedArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web-80"+Ext)
+	h := New()
+	h.Log.Entries = append(h.Log.Entries, Entry{Request: Request{URL: "http://web-80/saved"}})
+	if err := h.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendEntries(path, []Entry{{Request: Request{URL: "http://web-80/appended"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Log.Entries) != 2 || h.Log.Entries[1].Request.URL != "http://web-80/appended" {
+		t.Errorf("got entries %+v, want the saved and the appended one", h.Log.Entries)
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	dir := t.TempDir()
+	record(t, dir, "/a")
+	archives, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := NewReplayer(archives)
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{url: "http://web-80/a", status: http.StatusOK, body: "hello /a"},
+		{url: "http://web-80/a?page=2", status: http.StatusOK, body: "hello /a"},
+		{url: "http://web-80/b", status: http.StatusNotFound, body: "GET /b not recorded for web-80"},
+		{url: "http://api-80/a", status: http.StatusNotFound, body: "api-80 vhost not recorded"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		replayer.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rw.Code != tt.status || strings.TrimSpace(rw.Body.String()) != tt.body {
+			t.Errorf("%s: got %d %q, want %d %q", tt.url, rw.Code, rw.Body.String(), tt.status, tt.body)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package har
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Replayer serves the recorded responses of archives, keyed by vhost name,
+// for requests with the same host, method and URL. Requests matching
+// several entries get them in recorded order.
+type Replayer struct {
+	archives map[string]*HAR
+
+	lock sync.Mutex
+	next map[string]int
+}
+
+func NewReplayer(archives map[string]*HAR) *Replayer {
+	return &Replayer{
+		archives: archives,
+		next:     map[string]int{},
+	}
+}
+
+// Names returns the recorded vhost names.
+func (p *Replayer) Names() []string {
+	names := []string{}
+	for name := range p.archives {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (p *Replayer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	name := strings.ToLower(host)
+
+	h, ok := p.archives[name]
+	if !ok {
+		http.Error(rw, fmt.Sprintf("%s vhost not recorded", name), http.StatusNotFound)
+		return
+	}
+
+	entry := p.match(name, h, req)
+	if entry == nil {
+		http.Error(rw, fmt.Sprintf("%s %s not recorded for %s", req.Method, req.URL.RequestURI(), name), http.StatusNotFound)
+		return
+	}
+
+	body, err := entry.Response.Content.body()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header := rw.Header()
+	for _, h := range entry.Response.Headers {
+		switch http.CanonicalHeaderKey(h.Name) {
+		case "Content-Length", "Transfer-Encoding", "Connection":
+			continue
+		}
+		if h.Value == Redacted {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(entry.Response.Status)
+	rw.Write(body)
+}
+
+// match returns the next entry with the method and request URI of req,
+// falling back to the entries with the same method and path.
+func (p *Replayer) match(name string, h *HAR, req *http.Request) *Entry {
+	candidates := []*Entry{}
+	fallback := []*Entry{}
+	for i := range h.Log.Entries {
+		entry := &h.Log.Entries[i]
+		if entry.Request.Method != req.Method {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			continue
+		}
+		if u.RequestURI() == req.URL.RequestURI() {
+			candidates = append(candidates, entry)
+		}
+		if u.Path == req.URL.Path {
+			fallback = append(fallback, entry)
+		}
+	}
+
+	key := name + " " + req.Method + " " + req.URL.RequestURI()
+	if len(candidates) == 0 {
+		candidates = fallback
+		key = name + " " + req.Method + " " + req.URL.Path
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	i := p.next[key]
+	p.next[key] = i + 1
+	return candidates[i%len(candidates)]
+}
+
+func (c *Content) body() ([]byte, error) {
+	if c.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(c.Text)
+	}
+	return []byte(c.Text), nil
+}
@@ -0,0 +1,158 @@
+package vhost
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HeaderRules removes, sets and appends headers, in that order.
+type HeaderRules struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+func (r *HeaderRules) IsEmpty() bool {
+	return r == nil || (len(r.Set) == 0 && len(r.Add) == 0 && len(r.Remove) == 0)
+}
+
+// Names returns every header name used by the rules.
+func (r *HeaderRules) Names() []string {
+	names := append([]string{}, r.Remove...)
+	for name := range r.Set {
+		names = append(names, name)
+	}
+	for name := range r.Add {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (r *HeaderRules) Apply(header http.Header) {
+	if r == nil {
+		return
+	}
+	for _, name := range r.Remove {
+		header.Del(name)
+	}
+	for name, value := range r.Set {
+		header.Set(name, value)
+	}
+	for name, value := range r.Add {
+		header.Add(name, value)
+	}
+}
+
+// ApplyHPACK applies the rules to the fields of an HTTP/2 header block,
+// where they act on gRPC metadata. Pseudo headers are left alone.
+func (r *HeaderRules) ApplyHPACK(fields []hpack.HeaderField) []hpack.HeaderField {
+	if r.IsEmpty() {
+		return fields
+	}
+
+	drop := map[string]bool{}
+	for _, name := range r.Remove {
+		drop[strings.ToLower(name)] = true
+	}
+	for name := range r.Set {
+		drop[strings.ToLower(name)] = true
+	}
+
+	result := []hpack.HeaderField{}
+	for _, f := range fields {
+		if !f.IsPseudo() && drop[f.Name] {
+			continue
+		}
+		result = append(result, f)
+	}
+	for name, value := range r.Set {
+		result = append(result, hpack.HeaderField{Name: strings.ToLower(name), Value: value})
+	}
+	for name, value := range r.Add {
+		result = append(result, hpack.HeaderField{Name: strings.ToLower(name), Value: value})
+	}
+	return result
+}
+
+// SetAuthority replaces the :authority pseudo header of fields.
+func SetAuthority(fields []hpack.HeaderField, authority string) []hpack.HeaderField {
+	result := []hpack.HeaderField{}
+	for _, f := range fields {
+		if f.Name == ":authority" {
+			f.Value = authority
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// SetGRPCTimeout bounds the grpc-timeout of the requests in fields to
+// timeout. A shorter timeout of the client is kept, header blocks without
+// a :path, e.g. trailers, are left alone.
+// see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md#requests
+func SetGRPCTimeout(fields []hpack.HeaderField, timeout time.Duration) []hpack.HeaderField {
+	isRequest := false
+	for _, f := range fields {
+		if f.Name == ":path" {
+			isRequest = true
+		}
+	}
+	if !isRequest || timeout <= 0 {
+		return fields
+	}
+
+	result := []hpack.HeaderField{}
+	for _, f := range fields {
+		if f.Name == "grpc-timeout" {
+			if d, ok := parseGRPCTimeout(f.Value); ok && d <= timeout {
+				return fields
+			}
+			continue
+		}
+		result = append(result, f)
+	}
+	return append(result, hpack.HeaderField{Name: "grpc-timeout", Value: encodeGRPCTimeout(timeout)})
+}
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+func parseGRPCTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// encodeGRPCTimeout encodes d with at most 8 digits, rounding up.
+func encodeGRPCTimeout(d time.Duration) string {
+	const maxValue = 1e8 - 1
+	for _, u := range []struct {
+		unit byte
+		d    time.Duration
+	}{{'n', time.Nanosecond}, {'u', time.Microsecond}, {'m', time.Millisecond}, {'S', time.Second}, {'M', time.Minute}} {
+		n := (d + u.d - 1) / u.d
+		if n <= maxValue {
+			return strconv.FormatInt(int64(n), 10) + string(u.unit)
+		}
+	}
+	return strconv.FormatInt(int64((d+time.Hour-1)/time.Hour), 10) + "H"
+}
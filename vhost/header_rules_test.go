@@ -0,0 +1,138 @@
+package vhost
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+var testHeaderRules = &HeaderRules{
+	Set:    map[string]string{"X-Env": "staging"},
+	Add:    map[string]string{"X-Via": "vhost"},
+	Remove: []string{"Authorization"},
+}
+
+func TestHeaderRulesApply(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer x"},
+		"X-Env":         {"prod", "dev"},
+		"X-Via":         {"proxy"},
+	}
+	testHeaderRules.Apply(header)
+	want := http.Header{
+		"X-Env": {"staging"},
+		"X-Via": {"proxy", "vhost"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("got %v, want %v", header, want)
+	}
+}
+
+func TestHeaderRulesApplyHPACK(t *testing.T) {
+	fields := []hpack.HeaderField{
+		{Name: ":authority", Value: "api-8080"},
+		{Name: ":path", Value: "/api.Service/Call"},
+		{Name: "authorization", Value: "Bearer x"},
+		{Name: "x-env", Value: "prod"},
+	}
+	got := testHeaderRules.ApplyHPACK(fields)
+	want := []hpack.HeaderField{
+		{Name: ":authority", Value: "api-8080"},
+		{Name: ":path", Value: "/api.Service/Call"},
+		{Name: "x-env", Value: "staging"},
+		{Name: "x-via", Value: "vhost"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSetGRPCTimeout(t *testing.T) {
+	request := []hpack.HeaderField{{Name: ":path", Value: "/api.Service/Call"}}
+	tests := []struct {
+		name   string
+		fields []hpack.HeaderField
+		want   string
+	}{
+		{name: "no timeout", fields: request, want: "30000000u"},
+		{name: "longer timeout", fields: append(request, hpack.HeaderField{Name: "grpc-timeout", Value: "1M"}), want: "30000000u"},
+		{name: "shorter timeout", fields: append(request, hpack.HeaderField{Name: "grpc-timeout", Value: "5S"}), want: "5S"},
+		{name: "trailers", fields: []hpack.HeaderField{{Name: "grpc-status", Value: "0"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fieldValue(SetGRPCTimeout(tt.fields, 30*time.Second), "grpc-timeout")
+			if got != tt.want {
+				t.Errorf("got grpc-timeout %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func fieldValue(fields []hpack.HeaderField, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func TestGRPCHeaderRewriteConn(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	preface := &GRPCPreface{
+		StreamID:      1,
+		Header:        []hpack.HeaderField{{Name: ":path", Value: "/api.Service/Call"}, {Name: "authorization", Value: "Bearer x"}},
+		ClientPreface: []byte(http2.ClientPreface),
+	}
+	conn, rewritten := NewGRPCHeaderRewriteConn(local, preface, testHeaderRules.ApplyHPACK)
+	if fieldValue(rewritten.Header, "authorization") != "" || fieldValue(rewritten.Header, "x-env") != "staging" {
+		t.Errorf("got preface header %v, want the rules applied", rewritten.Header)
+	}
+
+	// the client opens a second stream with a header block of its own
+	go func() {
+		block := &bytes.Buffer{}
+		encoder := hpack.NewEncoder(block)
+		encoder.WriteField(hpack.HeaderField{Name: ":path", Value: "/api.Service/Other"})
+		encoder.WriteField(hpack.HeaderField{Name: "authorization", Value: "Bearer y"})
+		framer := http2.NewFramer(remote, nil)
+		framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, BlockFragment: block.Bytes(), EndHeaders: true})
+		framer.WriteData(3, true, []byte("payload"))
+	}()
+
+	framer := http2.NewFramer(nil, conn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, ok := frame.(*http2.MetaHeadersFrame)
+	if !ok {
+		t.Fatalf("got %T, want headers", frame)
+	}
+	want := []hpack.HeaderField{
+		{Name: ":path", Value: "/api.Service/Other"},
+		{Name: "x-env", Value: "staging"},
+		{Name: "x-via", Value: "vhost"},
+	}
+	if !reflect.DeepEqual(headers.Fields, want) {
+		t.Errorf("got fields %v, want %v", headers.Fields, want)
+	}
+
+	frame, err = framer.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := frame.(*http2.DataFrame); !ok || string(data.Data()) != "payload" || !data.StreamEnded() {
+		t.Errorf("got %v, want the data of the client", frame)
+	}
+}
@@ -2,46 +2,145 @@ package vhost
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/rest"
 )
 
-func (resolver *PortForwardResolver) NewRoundTripper(client rest.Interface, config *rest.Config, namespace string) http.RoundTripper {
-	return &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			host, _, err := net.SplitHostPort(addr)
-			if err != nil {
-				host = addr
+type portForwardTransport struct {
+	resolver  *PortForwardResolver
+	client    rest.Interface
+	config    *rest.Config
+	namespace string
+
+	proxyOnce      sync.Once
+	proxyTransport http.RoundTripper
+	proxyErr       error
+}
+
+func (t *portForwardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := req.URL.Host
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	var backend *PodBackend
+	var cookie *http.Cookie
+	entry := t.resolver.router.Resolve(host)
+	if entry != nil {
+		if target, ok := t.resolver.interceptTarget(entry); ok {
+			return t.resolver.interceptTransport(target).RoundTrip(req)
+		}
+		backend, cookie = t.resolveBackend(entry, req)
+	}
+	if backend == nil && entry != nil && t.resolver.ServiceProxyFallback {
+		return t.serviceProxy(entry, req)
+	}
+	if backend == nil {
+		err := fmt.Errorf("%s svc not found", addr)
+		runtime.HandleError(err)
+		return nil, err
+	}
+
+	if entry != nil && t.resolver.ServiceProxyFallback {
+		// dial ahead of the transport, which consumes the body of a request
+		// that may still go through the service proxy
+		_, err := backend.DialPortForwardOnce(t.client, t.config, t.namespace)
+		if err != nil && !errors.Is(err, ErrLimitExceeded) {
+			runtime.HandleError(err)
+			t.resolver.DeleteByName(backend.GetName())
+			return t.serviceProxy(entry, req)
+		}
+	}
+
+	release, err := t.resolver.acquireBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+	res, err := backend.Transport(t.dialer(backend)).RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &releaseBody{ReadCloser: res.Body, release: release}
+	if cookie != nil {
+		res.Header.Add("Set-Cookie", cookie.String())
+	}
+	return res, nil
+}
+
+// resolveBackend picks the pod for req, honoring the service's ClientIP
+// affinity or, when enabled, the affinity cookie. A cookie is returned when
+// the client has to be issued a new one.
+func (t *portForwardTransport) resolveBackend(entry *ServicePortEntry, req *http.Request) (*PodBackend, *http.Cookie) {
+	timeout := entry.SessionAffinityTimeout()
+	name := t.resolver.AffinityCookieName
+	if name == "" {
+		return t.resolver.resolveSticky(entry, clientIP(req.RemoteAddr), timeout), nil
+	}
+
+	if timeout == 0 {
+		timeout = DefaultCookieAffinityTimeout
+	}
+	if c, err := req.Cookie(name); err == nil && c.Value != "" {
+		return t.resolver.resolveSticky(entry, "cookie:"+c.Value, timeout), nil
+	}
+
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    newAffinityKey(),
+		Path:     "/",
+		HttpOnly: true,
+	}
+	return t.resolver.resolveSticky(entry, "cookie:"+cookie.Value, timeout), cookie
+}
+
+func (t *portForwardTransport) dialer(backend *PodBackend) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := backend.DialPortForwardOnce(t.client, t.config, t.namespace)
+		if err != nil {
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.resolver.DeleteByName(backend.GetName())
 			}
-			backend := resolver.ResolveBackend(host)
-			if backend == nil {
-				err := fmt.Errorf("%s svc not found", addr)
+			return nil, err
+		}
+		conn.OnCreateStream = backend.OnCreateStream
+		conn.OnCloseStream = backend.OnCloseStream
+
+		local, remote := net.Pipe()
+		go func() {
+			defer local.Close()
+			defer remote.Close()
+			status := &statusConn{Conn: remote}
+			err := conn.Forward(status, uint16(backend.GetTargetPort()), nil)
+			var forwardErr *PortForwardError
+			if errors.As(err, &forwardErr) {
 				runtime.HandleError(err)
-				return nil, err
+				if !status.Written() {
+					writeHTTPError(remote, err)
+				}
+				return
 			}
-
-			conn, err := backend.DialPortForwardOnce(client, config, namespace)
 			if err != nil {
-				resolver.DeleteByName(backend.GetName())
-				return nil, err
+				t.resolver.DeleteByName(backend.GetName())
 			}
-			conn.OnCreateStream = backend.OnCreateStream
-			conn.OnCloseStream = backend.OnCloseStream
-
-			local, remote := net.Pipe()
-			go func() {
-				defer local.Close()
-				err := conn.Forward(remote, uint16(backend.GetTargetPort()), nil)
-				if err != nil {
-					resolver.DeleteByName(backend.GetName())
-				}
-			}()
+		}()
 
-			return local, nil
-		},
+		return local, nil
+	}
+}
+
+func (resolver *PortForwardResolver) NewRoundTripper(client rest.Interface, config *rest.Config, namespace string) http.RoundTripper {
+	return &portForwardTransport{
+		resolver:  resolver,
+		client:    client,
+		config:    config,
+		namespace: namespace,
 	}
 }
@@ -0,0 +1,136 @@
+package vhost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// interceptDialer dials intercept targets.
+var interceptDialer = &net.Dialer{}
+
+// Intercept sends the traffic of the service port served under hostname
+// to target, a local host:port, instead of to its pods.
+func (resolver *PortForwardResolver) Intercept(hostname string, target string) error {
+	entry := resolver.router.Resolve(hostname)
+	if entry == nil {
+		return fmt.Errorf("%s svc not found", hostname)
+	}
+	resolver.interceptLock.Lock()
+	defer resolver.interceptLock.Unlock()
+	intercepts := resolver.copyIntercepts()
+	intercepts[entry.SourceHostName()] = target
+	resolver.intercepts = intercepts
+	return nil
+}
+
+// RemoveIntercept sends the traffic of hostname to its pods again.
+func (resolver *PortForwardResolver) RemoveIntercept(hostname string) {
+	entry := resolver.router.Resolve(hostname)
+	if entry == nil {
+		return
+	}
+	resolver.interceptLock.Lock()
+	defer resolver.interceptLock.Unlock()
+	intercepts := resolver.copyIntercepts()
+	delete(intercepts, entry.SourceHostName())
+	resolver.intercepts = intercepts
+}
+
+// SetIntercepts replaces every intercept, keyed by any host name of a
+// service port. It fails without changing anything when a host name is
+// not served.
+func (resolver *PortForwardResolver) SetIntercepts(intercepts map[string]string) error {
+	resolved := map[string]string{}
+	for hostname, target := range intercepts {
+		entry := resolver.router.Resolve(hostname)
+		if entry == nil {
+			return fmt.Errorf("%s svc not found", hostname)
+		}
+		resolved[entry.SourceHostName()] = target
+	}
+	resolver.interceptLock.Lock()
+	defer resolver.interceptLock.Unlock()
+	resolver.intercepts = resolved
+	return nil
+}
+
+// Intercepts returns the intercept targets keyed by source host name.
+func (resolver *PortForwardResolver) Intercepts() map[string]string {
+	resolver.interceptLock.Lock()
+	defer resolver.interceptLock.Unlock()
+	return resolver.copyIntercepts()
+}
+
+func (resolver *PortForwardResolver) copyIntercepts() map[string]string {
+	intercepts := map[string]string{}
+	for k, v := range resolver.intercepts {
+		intercepts[k] = v
+	}
+	return intercepts
+}
+
+// InterceptTarget returns the local target of hostname, if it is
+// intercepted.
+func (resolver *PortForwardResolver) InterceptTarget(hostname string) (string, bool) {
+	entry := resolver.router.Resolve(hostname)
+	if entry == nil {
+		return "", false
+	}
+	return resolver.interceptTarget(entry)
+}
+
+func (resolver *PortForwardResolver) interceptTarget(entry *ServicePortEntry) (string, bool) {
+	resolver.interceptLock.Lock()
+	defer resolver.interceptLock.Unlock()
+	target, ok := resolver.intercepts[entry.SourceHostName()]
+	return target, ok
+}
+
+// interceptTransport returns the HTTP transport bound to target.
+func (resolver *PortForwardResolver) interceptTransport(target string) http.RoundTripper {
+	v, ok := resolver.interceptTransports.Load(target)
+	if !ok {
+		v, _ = resolver.interceptTransports.LoadOrStore(target, &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return interceptDialer.DialContext(ctx, "tcp", target)
+			},
+			IdleConnTimeout: idleConnTimeout,
+		})
+	}
+	transport, _ := v.(http.RoundTripper)
+	return transport
+}
+
+// forwardIntercept copies a hijacked gRPC connection to target.
+func forwardIntercept(local net.Conn, preface *GRPCPreface, target string) error {
+	remote, err := interceptDialer.Dial("tcp", target)
+	if err != nil {
+		return err
+	}
+	if _, err := remote.Write(preface.ClientPreface); err != nil {
+		remote.Close()
+		return err
+	}
+
+	go func() {
+		defer local.Close()
+		defer remote.Close()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(remote, local)
+			if c, ok := remote.(*net.TCPConn); ok {
+				c.CloseWrite()
+			}
+		}()
+		io.Copy(local, remote)
+		local.Close()
+		wg.Wait()
+	}()
+	return nil
+}
@@ -0,0 +1,100 @@
+package vhost
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josudoey/kube/kubetest"
+	"golang.org/x/net/http2"
+)
+
+func TestIntercept(t *testing.T) {
+	resolver := NewPortForwardResolver()
+	resolver.AddService(newWebService())
+
+	if err := resolver.Intercept("web-http", "localhost:3000"); err != nil {
+		t.Fatal(err)
+	}
+	// every host name of the service port is intercepted
+	if target, ok := resolver.InterceptTarget("web-80"); !ok || target != "localhost:3000" {
+		t.Errorf("got %q, want web-80 intercepted by localhost:3000", target)
+	}
+	if err := resolver.Intercept("api-80", "localhost:3001"); err == nil {
+		t.Error("got no error intercepting an unknown host name")
+	}
+	if err := resolver.SetIntercepts(map[string]string{"web-80": "localhost:3002", "api-80": "localhost:3001"}); err == nil {
+		t.Error("got no error setting an unknown host name")
+	}
+	if target, _ := resolver.InterceptTarget("web-80"); target != "localhost:3000" {
+		t.Errorf("got %q after a failed SetIntercepts, want localhost:3000", target)
+	}
+
+	resolver.RemoveIntercept("web-80")
+	if target, ok := resolver.InterceptTarget("web-http"); ok {
+		t.Errorf("got web-http intercepted by %q after RemoveIntercept", target)
+	}
+}
+
+func TestInterceptHTTP(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("local " + req.URL.Path))
+	}))
+	defer local.Close()
+	resolver := NewPortForwardResolver()
+	resolver.AddService(newWebService())
+	if err := resolver.Intercept("web-80", strings.TrimPrefix(local.URL, "http://")); err != nil {
+		t.Fatal(err)
+	}
+
+	// without pods or an API server the request can only reach the target
+	req := httptest.NewRequest(http.MethodGet, "http://web-80/status", nil)
+	res, err := resolver.NewRoundTripper(nil, nil, "default").RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if body, _ := ioutil.ReadAll(res.Body); string(body) != "local /status" {
+		t.Errorf("got %q, want the response of the local target", body)
+	}
+}
+
+func TestForwardIntercept(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	local, client := net.Pipe()
+	preface := &GRPCPreface{ClientPreface: []byte(http2.ClientPreface)}
+	if err := forwardIntercept(local, preface, listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(client, "frames")
+	client.Close()
+
+	select {
+	case data := <-received:
+		if data != http2.ClientPreface+"frames" {
+			t.Errorf("got %q, want the preface followed by the client frames", data)
+		}
+	case <-time.After(kubetest.WatchTimeout):
+		t.Fatal("target got nothing")
+	}
+}
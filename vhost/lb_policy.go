@@ -0,0 +1,59 @@
+package vhost
+
+import (
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+// LBPolicy selects which ready pod of a service port serves a new client.
+type LBPolicy string
+
+const (
+	// LBPolicyFirst keeps sending clients to the ready pod first in name
+	// order.
+	LBPolicyFirst LBPolicy = "first"
+	// LBPolicyRandom picks a ready pod at random.
+	LBPolicyRandom LBPolicy = "random"
+	// LBPolicyRoundRobin cycles through the ready pods in name order.
+	LBPolicyRoundRobin LBPolicy = "round-robin"
+)
+
+var LBPolicies = []LBPolicy{LBPolicyFirst, LBPolicyRandom, LBPolicyRoundRobin}
+
+func (policy LBPolicy) IsValid() bool {
+	for _, p := range LBPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PodBackendSet) Values() []*PodBackend {
+	values := []*PodBackend{}
+	p.Range(func(value *PodBackend) bool {
+		values = append(values, value)
+		return true
+	})
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].GetName() < values[j].GetName()
+	})
+	return values
+}
+
+// Pick returns a backend of the set chosen by policy.
+func (p *PodBackendSet) Pick(policy LBPolicy) *PodBackend {
+	values := p.Values()
+	if len(values) == 0 {
+		return nil
+	}
+	switch policy {
+	case LBPolicyRandom:
+		return values[rand.Intn(len(values))]
+	case LBPolicyRoundRobin:
+		next := atomic.AddUint32(&p.next, 1)
+		return values[int(next-1)%len(values)]
+	}
+	return values[0]
+}
@@ -0,0 +1,44 @@
+package vhost
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+)
+
+func newBackendSet(names ...string) *PodBackendSet {
+	set := &PodBackendSet{}
+	for _, name := range names {
+		set.Add(NewPodBackend(&MatchedPod{Pod: *kubetest.NewPod(name)}))
+	}
+	return set
+}
+
+func TestPickFirst(t *testing.T) {
+	set := newBackendSet("web-2", "web-0", "web-1")
+	for i := 0; i < 20; i++ {
+		if name := set.Pick(LBPolicyFirst).GetName(); name != "web-0" {
+			t.Fatalf("pick %d: got %s, want web-0", i, name)
+		}
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	set := newBackendSet("web-1", "web-0")
+	got := []string{}
+	for i := 0; i < 4; i++ {
+		got = append(got, set.Pick(LBPolicyRoundRobin).GetName())
+	}
+	if want := "[web-0 web-1 web-0 web-1]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestPickEmpty(t *testing.T) {
+	for _, policy := range LBPolicies {
+		if backend := newBackendSet().Pick(policy); backend != nil {
+			t.Errorf("%s: got %s from an empty set", policy, backend.GetName())
+		}
+	}
+}
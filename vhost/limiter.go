@@ -0,0 +1,120 @@
+package vhost
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// ErrLimitExceeded is returned when a rate limit or a concurrency cap
+// rejects a request.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limit configures a Limiter. Zero values disable the matching check.
+type Limit struct {
+	QPS           float32 `json:"qps,omitempty"`
+	Burst         int     `json:"burst,omitempty"`
+	MaxConcurrent int     `json:"maxConcurrent,omitempty"`
+}
+
+func (l *Limit) IsEmpty() bool {
+	return l == nil || (l.QPS == 0 && l.MaxConcurrent == 0)
+}
+
+// Limiter combines a token bucket rate limit with a cap on concurrent
+// requests. A nil Limiter accepts everything.
+type Limiter struct {
+	lock          sync.Mutex
+	rateLimiter   flowcontrol.PassiveRateLimiter
+	qps           float32
+	burst         int
+	active        int
+	maxConcurrent int
+}
+
+// NewLimiter returns a limiter for limit, or nil when limit is empty.
+func NewLimiter(limit *Limit) *Limiter {
+	if limit.IsEmpty() {
+		return nil
+	}
+	l := &Limiter{}
+	l.SetLimit(limit)
+	return l
+}
+
+// SetLimit changes the limits of l in place. The requests in flight keep
+// their slots, and the rate limiter keeps its tokens unless its rate
+// changes.
+func (l *Limiter) SetLimit(limit *Limit) {
+	if limit == nil {
+		limit = &Limit{}
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.maxConcurrent = limit.MaxConcurrent
+	if limit.QPS == l.qps && burst == l.burst {
+		return
+	}
+	l.qps = limit.QPS
+	l.burst = burst
+	l.rateLimiter = nil
+	if limit.QPS > 0 {
+		l.rateLimiter = flowcontrol.NewTokenBucketPassiveRateLimiter(limit.QPS, burst)
+	}
+}
+
+// Acquire takes a slot without waiting. The returned release must be
+// called once the request is done.
+func (l *Limiter) Acquire() (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.maxConcurrent > 0 && l.active >= l.maxConcurrent {
+		return nil, fmt.Errorf("%w: %d concurrent requests", ErrLimitExceeded, l.maxConcurrent)
+	}
+	if l.rateLimiter != nil && !l.rateLimiter.TryAccept() {
+		return nil, fmt.Errorf("%w: rate limited", ErrLimitExceeded)
+	}
+
+	l.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			l.active--
+		})
+	}, nil
+}
+
+var portForwardConnections = &Limiter{}
+
+// SetMaxPortForwardConnections caps the number of open connections created
+// by DialPortForwardConnection. Zero removes the cap.
+func SetMaxPortForwardConnections(n int) {
+	portForwardConnections.lock.Lock()
+	defer portForwardConnections.lock.Unlock()
+	portForwardConnections.maxConcurrent = n
+}
+
+// releaseBody releases a limiter slot when the response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
@@ -1,6 +1,7 @@
 package vhost
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/httpstream"
@@ -34,24 +37,60 @@ func (r *RequestIDGenerator) NextRequestID() int {
 }
 
 type PortForwardConnection struct {
+	// PodName is the pod the connection forwards to.
+	PodName string
+
 	OnCreateStream func(id int)
 	OnCloseStream  func(id int)
 
 	RequestIDGenerator
 	httpstream.Connection
 	wg sync.WaitGroup
+
+	streamLock sync.Mutex
+	streams    int
+	idleSince  time.Time
+}
+
+func (forwarder *PortForwardConnection) beginStream() {
+	forwarder.wg.Add(1)
+	forwarder.streamLock.Lock()
+	defer forwarder.streamLock.Unlock()
+	forwarder.streams++
+}
+
+func (forwarder *PortForwardConnection) endStream() {
+	forwarder.streamLock.Lock()
+	forwarder.streams--
+	if forwarder.streams == 0 {
+		forwarder.idleSince = time.Now()
+	}
+	forwarder.streamLock.Unlock()
+	forwarder.wg.Done()
+}
+
+// IdleSince returns when the last stream of the connection was closed.
+// It returns false while streams are still active.
+func (forwarder *PortForwardConnection) IdleSince() (time.Time, bool) {
+	forwarder.streamLock.Lock()
+	defer forwarder.streamLock.Unlock()
+	if forwarder.streams > 0 {
+		return time.Time{}, false
+	}
+	return forwarder.idleSince, true
 }
 
 // Forward copies data between the local connection and the stream to
-// the remote server.
+// the remote server. An error reported by the kubelet on the error stream
+// is returned as a *PortForwardError.
 // see https://github.com/kubernetes/client-go/blob/94daee0164805ef86cc36790c662b7f074db10ec/tools/portforward/portforward.go#L324
 // see https://github.com/kubernetes/kubernetes/blob/10ed4502f46d763a809ccdcc6c30be1c03e19147/pkg/kubelet/cri/streaming/server.go#L132
 // see https://github.com/kubernetes/kubernetes/blob/10ed4502f46d763a809ccdcc6c30be1c03e19147/pkg/kubelet/cri/streaming/portforward/portforward.go#L41
 // see https://github.com/kubernetes/kubernetes/blob/10ed4502f46d763a809ccdcc6c30be1c03e19147/pkg/kubelet/cri/streaming/portforward/httpstream.go#L36
 // see https://github.com/kubernetes/kubernetes/blob/10ed4502f46d763a809ccdcc6c30be1c03e19147/pkg/kubelet/cri/streaming/portforward/httpstream.go#L74
 func (forwarder *PortForwardConnection) Forward(conn net.Conn, port uint16, clientPreface []byte) error {
-	forwarder.wg.Add(1)
-	defer forwarder.wg.Done()
+	forwarder.beginStream()
+	defer forwarder.endStream()
 	defer conn.Close()
 	requestID := forwarder.NextRequestID()
 
@@ -67,14 +106,13 @@ func (forwarder *PortForwardConnection) Forward(conn net.Conn, port uint16, clie
 	// we're not writing to this stream
 	errorStream.Close()
 
+	errorMessage := make(chan string, 1)
 	go func() {
 		message, err := ioutil.ReadAll(errorStream)
 		if err != nil {
 			runtime.HandleError(fmt.Errorf("error reading from error stream for port %d: %v", port, err))
 		}
-		if len(message) > 0 {
-			runtime.HandleError(fmt.Errorf("an error occurred forwarding %d: %v", port, string(message)))
-		}
+		errorMessage <- string(message)
 	}()
 
 	// create data stream
@@ -90,6 +128,7 @@ func (forwarder *PortForwardConnection) Forward(conn net.Conn, port uint16, clie
 	}
 	localError := make(chan struct{})
 	remoteDone := make(chan struct{})
+	remoteFailed := false
 
 	dataStream.Write(clientPreface)
 	go func() {
@@ -97,7 +136,10 @@ func (forwarder *PortForwardConnection) Forward(conn net.Conn, port uint16, clie
 		defer close(remoteDone)
 
 		// Copy from the remote side to the local port.
-		_, err := io.Copy(conn, dataStream)
+		n, err := io.Copy(conn, dataStream)
+		// the kubelet closes the data stream without any data when it
+		// cannot reach the port in the pod
+		remoteFailed = err != nil || n == 0
 		if err == nil {
 			return
 		}
@@ -130,14 +172,41 @@ func (forwarder *PortForwardConnection) Forward(conn net.Conn, port uint16, clie
 	}()
 
 	// wait for either a local->remote error or for copying from remote->local to finish
+	var message string
 	select {
 	case <-remoteDone:
+		if !remoteFailed {
+			select {
+			case message = <-errorMessage:
+			default:
+			}
+			break
+		}
+		// the kubelet closes the error stream right after the data stream
+		timer := time.NewTimer(errorStreamTimeout)
+		select {
+		case message = <-errorMessage:
+		case <-forwarder.CloseChan():
+		case <-timer.C:
+		}
+		timer.Stop()
 	case <-localError:
+		select {
+		case message = <-errorMessage:
+		default:
+		}
 	}
 
 	if forwarder.OnCloseStream != nil {
 		go forwarder.OnCloseStream(requestID)
 	}
+	if message != "" {
+		return &PortForwardError{
+			Pod:     forwarder.PodName,
+			Port:    port,
+			Message: message,
+		}
+	}
 	return nil
 }
 
@@ -146,6 +215,28 @@ func (forwarder *PortForwardConnection) Close() error {
 	return forwarder.Connection.Close()
 }
 
+// Shutdown waits for active streams to finish and closes the connection.
+// When ctx is done first the connection is closed anyway and ctx.Err() is
+// returned.
+func (forwarder *PortForwardConnection) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		forwarder.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	err := forwarder.Connection.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 func DialPortForwardConnection(client rest.Interface, config *rest.Config, namespace string, podName string) (*PortForwardConnection, error) {
 	var err error
 	req := client.Post().
@@ -161,14 +252,26 @@ func DialPortForwardConnection(client rest.Interface, config *rest.Config, names
 		return nil, err
 	}
 
+	release, err := portForwardConnections.Acquire()
+	if err != nil {
+		return nil, fmt.Errorf("port-forward connections: %w", err)
+	}
+
 	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, method, portforwardURL)
 	streamConn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
 	if err != nil {
+		release()
 		return nil, err
 	}
+	go func() {
+		<-streamConn.CloseChan()
+		release()
+	}()
 
 	return &PortForwardConnection{
+		PodName:    podName,
 		Connection: streamConn,
+		idleSince:  time.Now(),
 	}, nil
 }
 
@@ -195,12 +298,51 @@ func (resolver *PortForwardResolver) AddService(svc v1.Service) []*ServicePortEn
 			ServicePort: svcPort,
 			Selector:    selector,
 		}
+		if invalid := entry.InvalidHostNames(); len(invalid) > 0 {
+			runtime.HandleError(fmt.Errorf("svc/%s: ignoring invalid host names %v of %s", entry.SourceHostPort(), invalid, HostNamesAnnotation))
+		}
 
-		resolver.router.AddIfNotExists(entry)
+		_, loaded, conflicts := resolver.router.add(entry)
+		if !loaded {
+			// pods may be known before their service, e.g. from an informer
+			resolver.pods.Range(func(pod *v1.Pod) bool {
+				if entry.Match(*pod) {
+					resolver.addBackend(entry, *pod)
+				}
+				return true
+			})
+			if resolver.OnAddService != nil {
+				go resolver.OnAddService(*entry)
+			}
+		}
+		if resolver.OnHostNameConflict == nil {
+			continue
+		}
+		for _, conflict := range conflicts {
+			go resolver.OnHostNameConflict(conflict)
+		}
 	}
 	return nil
 }
 
+// RemoveService drops the ports of svc and their backends, e.g. when the
+// service is deleted or before an updated version is added again.
+func (resolver *PortForwardResolver) RemoveService(svc v1.Service) {
+	for _, entry := range resolver.router.Values() {
+		if entry.Service.Namespace != svc.Namespace || entry.Service.Name != svc.Name {
+			continue
+		}
+		resolver.router.remove(entry)
+		for _, backend := range resolver.activeBackend.Remove(entry) {
+			resolver.podLimiters.Delete(backend)
+			backend.remove()
+		}
+		if resolver.OnRemoveService != nil {
+			go resolver.OnRemoveService(*entry)
+		}
+	}
+}
+
 func (resolver *PortForwardResolver) AddPod(pod v1.Pod) {
 	podName := pod.GetName()
 	ready := podutils.IsPodReady(&pod)
@@ -217,23 +359,41 @@ func (resolver *PortForwardResolver) AddPod(pod v1.Pod) {
 		if !service.Match(pod) {
 			continue
 		}
+		resolver.addBackend(service, pod)
+	}
+}
 
-		matchedPod := &MatchedPod{
-			ServicePort: service.ServicePort,
-			Pod:         pod,
-		}
+func (resolver *PortForwardResolver) addBackend(service *ServicePortEntry, pod v1.Pod) {
+	// AddService and AddPod may both match the same pod concurrently
+	resolver.backendLock.Lock()
+	defer resolver.backendLock.Unlock()
+	if set, ok := resolver.activeBackend.Get(service); ok && set.GetByName(pod.GetName()) != nil {
+		return
+	}
 
-		backend := NewPodBackend(matchedPod)
-		resolver.activeBackend.Add(service, backend)
-		if resolver.OnAddServiceBackend == nil {
-			continue
-		}
-		go resolver.OnAddServiceBackend(*service, backend)
+	matchedPod := &MatchedPod{
+		ServicePort: service.ServicePort,
+		Pod:         pod,
 	}
+
+	backend := NewPodBackend(matchedPod)
+	backend.entry = service
+	resolver.activeBackend.Add(service, backend)
+	if resolver.OnAddServiceBackend == nil {
+		return
+	}
+	go resolver.OnAddServiceBackend(*service, backend)
 }
 
 func (resolver *PortForwardResolver) DeleteByName(podName string) {
 	resolver.pods.Delete(podName)
+	resolver.affinity.DeleteByName(podName)
+	resolver.podLimiters.Range(func(k, v interface{}) bool {
+		if backend, _ := k.(*PodBackend); backend.GetName() == podName {
+			resolver.podLimiters.Delete(k)
+		}
+		return true
+	})
 	resolver.activeBackend.Range(func(key *ServicePortEntry, value *PodBackendSet) bool {
 		value.DeleteByName(podName)
 		return true
@@ -263,7 +423,81 @@ func (resolver *PortForwardResolver) ResolveBackend(hostname string) *PodBackend
 		return nil
 	}
 
-	return resolver.activeBackend.GetOne(entry)
+	set, ok := resolver.activeBackend.Get(entry)
+	if !ok {
+		return nil
+	}
+	return set.Pick(resolver.lbPolicy(entry))
+}
+
+// ResolveService returns the service port entry served under hostname.
+func (resolver *PortForwardResolver) ResolveService(hostname string) *ServicePortEntry {
+	return resolver.router.Resolve(hostname)
+}
+
+// ReadyPods returns the number of ready pods backing the service port
+// served under hostname.
+func (resolver *PortForwardResolver) ReadyPods(hostname string) int {
+	entry := resolver.router.Resolve(hostname)
+	if entry == nil {
+		return 0
+	}
+	set, ok := resolver.activeBackend.Get(entry)
+	if !ok {
+		return 0
+	}
+	return len(set.Values())
+}
+
+// SetLBPolicies replaces the load balancing policies, keyed by the source
+// host name of a service port. Ports without a policy use LBPolicyFirst.
+func (resolver *PortForwardResolver) SetLBPolicies(policies map[string]LBPolicy) {
+	resolver.lbPolicies.Store(policies)
+}
+
+func (resolver *PortForwardResolver) lbPolicy(entry *ServicePortEntry) LBPolicy {
+	policies, _ := resolver.lbPolicies.Load().(map[string]LBPolicy)
+	if policy, ok := policies[entry.SourceHostName()]; ok {
+		return policy
+	}
+	return LBPolicyFirst
+}
+
+// ResolveBackendFor returns a backend for hostname and keeps the client on
+// the same pod while the service's ClientIP session affinity holds.
+func (resolver *PortForwardResolver) ResolveBackendFor(hostname string, clientAddr string) *PodBackend {
+	entry := resolver.router.Resolve(hostname)
+	if entry == nil {
+		return nil
+	}
+
+	return resolver.resolveSticky(entry, clientIP(clientAddr), entry.SessionAffinityTimeout())
+}
+
+func (resolver *PortForwardResolver) resolveSticky(entry *ServicePortEntry, client string, timeout time.Duration) *PodBackend {
+	set, ok := resolver.activeBackend.Get(entry)
+	if !ok {
+		return nil
+	}
+	policy := resolver.lbPolicy(entry)
+	if timeout == 0 || client == "" {
+		return set.Pick(policy)
+	}
+
+	now := time.Now()
+	if podName, ok := resolver.affinity.Get(entry, client, now); ok {
+		if backend := set.GetByName(podName); backend != nil {
+			resolver.affinity.Set(entry, client, podName, now.Add(timeout))
+			return backend
+		}
+	}
+
+	backend := set.Pick(policy)
+	if backend == nil {
+		return nil
+	}
+	resolver.affinity.Set(entry, client, backend.GetName(), now.Add(timeout))
+	return backend
 }
 
 func (resolver *PortForwardResolver) ResolveAddr(addr string) string {
@@ -278,6 +512,21 @@ func (resolver *PortForwardResolver) ResolveAddr(addr string) string {
 	return backend.GetTargetHostPort()
 }
 
+// ListHostNames returns the host names routed to the service port of entry.
+func (resolver *PortForwardResolver) ListHostNames(entry ServicePortEntry) []string {
+	item := resolver.router.Resolve(entry.SourceHostPort())
+	if item == nil {
+		return nil
+	}
+	return resolver.router.HostNames(item)
+}
+
+// Conflicts returns the host names that were claimed by more than one
+// service port.
+func (resolver *PortForwardResolver) Conflicts() []HostNameConflict {
+	return resolver.router.Conflicts()
+}
+
 func (resolver *PortForwardResolver) ListServices() []ServicePortEntry {
 	items := []ServicePortEntry{}
 	for _, item := range resolver.router.Values() {
@@ -286,12 +535,104 @@ func (resolver *PortForwardResolver) ListServices() []ServicePortEntry {
 	return items
 }
 
+// SetPodLimits replaces the limits applied to each pod backend of a
+// service port, keyed by the source host name of the port.
+func (resolver *PortForwardResolver) SetPodLimits(limits map[string]*Limit) {
+	resolver.podLimits.Store(limits)
+	resolver.podLimiters.Range(func(k, v interface{}) bool {
+		resolver.podLimiters.Delete(k)
+		return true
+	})
+}
+
+// acquireBackend takes a slot of the pod limit of backend.
+func (resolver *PortForwardResolver) acquireBackend(backend *PodBackend) (func(), error) {
+	limits, _ := resolver.podLimits.Load().(map[string]*Limit)
+	if backend.entry == nil {
+		return func() {}, nil
+	}
+	limit := limits[backend.entry.SourceHostName()]
+	if limit.IsEmpty() {
+		return func() {}, nil
+	}
+	v, _ := resolver.podLimiters.LoadOrStore(backend, NewLimiter(limit))
+	release, err := v.(*Limiter).Acquire()
+	if err != nil {
+		return nil, fmt.Errorf("pod %s: %w", backend.GetName(), err)
+	}
+	return release, nil
+}
+
+// Backends returns every pod backend known to the resolver.
+func (resolver *PortForwardResolver) Backends() []*PodBackend {
+	backends := []*PodBackend{}
+	resolver.activeBackend.Range(func(key *ServicePortEntry, value *PodBackendSet) bool {
+		value.Range(func(backend *PodBackend) bool {
+			backends = append(backends, backend)
+			return true
+		})
+		return true
+	})
+	return backends
+}
+
+// CloseIdle closes the port-forward connections that have had no streams
+// for at least timeout. The backends dial again on the next request.
+func (resolver *PortForwardResolver) CloseIdle(timeout time.Duration) {
+	for _, backend := range resolver.Backends() {
+		backend.CloseIdle(timeout)
+	}
+}
+
+// Shutdown drains the active streams of every backend and closes all
+// port-forward connections. It returns ctx.Err() if ctx is done before
+// the streams have drained.
+func (resolver *PortForwardResolver) Shutdown(ctx context.Context) error {
+	backends := resolver.Backends()
+	errs := make(chan error, len(backends))
+	for _, backend := range backends {
+		go func(backend *PodBackend) {
+			errs <- backend.Shutdown(ctx)
+		}(backend)
+	}
+
+	var err error
+	for range backends {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 type PortForwardResolver struct {
 	router        ServicePortEntryRouter
 	pods          PodMap
 	activeBackend ServiceBackend
-
+	backendLock   sync.Mutex
+	affinity      SessionAffinity
+	lbPolicies    atomic.Value
+	podLimits     atomic.Value
+	podLimiters   sync.Map
+
+	interceptLock       sync.Mutex
+	intercepts          map[string]string
+	interceptTransports sync.Map
+
+	// AffinityCookieName enables cookie based session affinity for HTTP
+	// vhosts when set.
+	AffinityCookieName string
+
+	// ServiceProxyFallback sends HTTP requests through the services/proxy
+	// subresource of the API server when no pod of the service is ready or
+	// its port-forward cannot be dialed, e.g. when pods/portforward is
+	// denied.
+	ServiceProxyFallback bool
+
+	OnAddService        func(entry ServicePortEntry)
+	OnRemoveService     func(entry ServicePortEntry)
 	OnAddServiceBackend func(entry ServicePortEntry, backend *PodBackend)
+	OnHostNameConflict  func(conflict HostNameConflict)
 }
 
 func NewPortForwardResolver() *PortForwardResolver {
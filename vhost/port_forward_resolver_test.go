@@ -0,0 +1,123 @@
+package vhost
+
+import (
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var webLabels = map[string]string{"app": "web"}
+
+func newWebService(opts ...kubetest.ServiceOption) corev1.Service {
+	opts = append([]kubetest.ServiceOption{
+		kubetest.WithServiceSelector(webLabels),
+		kubetest.WithServicePort("http", 80, 8080),
+	}, opts...)
+	return *kubetest.NewService("web", opts...)
+}
+
+func newWebPod(name string, opts ...kubetest.PodOption) corev1.Pod {
+	opts = append([]kubetest.PodOption{
+		kubetest.WithPodLabels(webLabels),
+		kubetest.WithPodPort("http", 8080),
+	}, opts...)
+	return *kubetest.NewPod(name, opts...)
+}
+
+func TestPortForwardResolverAddOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		add  func(resolver *PortForwardResolver)
+	}{
+		{
+			name: "service first",
+			add: func(resolver *PortForwardResolver) {
+				resolver.AddService(newWebService())
+				resolver.AddPod(newWebPod("web-0", kubetest.WithPodReady()))
+			},
+		},
+		{
+			name: "pod first",
+			add: func(resolver *PortForwardResolver) {
+				resolver.AddPod(newWebPod("web-0", kubetest.WithPodReady()))
+				resolver.AddService(newWebService())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := NewPortForwardResolver()
+			tt.add(resolver)
+
+			for _, hostname := range []string{"web-80", "web-http"} {
+				backend := resolver.ResolveBackend(hostname)
+				if backend == nil {
+					t.Fatalf("%s: no backend", hostname)
+				}
+				if backend.GetName() != "web-0" || backend.GetTargetPort() != 8080 {
+					t.Errorf("%s: got %s port %d, want web-0 port 8080", hostname, backend.GetName(), backend.GetTargetPort())
+				}
+			}
+			if n := resolver.ReadyPods("web-80"); n != 1 {
+				t.Errorf("got %d ready pods, want 1", n)
+			}
+		})
+	}
+}
+
+func TestPortForwardResolverFollowsReadiness(t *testing.T) {
+	resolver := NewPortForwardResolver()
+	resolver.AddService(newWebService())
+	pod := newWebPod("web-0", kubetest.WithPodNotReady("ContainerCreating", ""))
+	resolver.AddPod(pod)
+	if backend := resolver.ResolveBackend("web-80"); backend != nil {
+		t.Fatalf("got backend %s of a pod that is not ready", backend.GetName())
+	}
+
+	ready := newWebPod("web-0", kubetest.WithPodReady())
+	resolver.UpdatePod(&ready)
+	if resolver.ResolveBackend("web-80") == nil {
+		t.Fatal("no backend once the pod is ready")
+	}
+
+	resolver.UpdatePod(&pod)
+	if backend := resolver.ResolveBackend("web-80"); backend != nil {
+		t.Errorf("got backend %s once the pod is not ready again", backend.GetName())
+	}
+}
+
+func TestPortForwardResolverRemoveService(t *testing.T) {
+	resolver := NewPortForwardResolver()
+	svc := newWebService()
+	resolver.AddService(svc)
+	resolver.AddPod(newWebPod("web-0", kubetest.WithPodReady()))
+
+	resolver.RemoveService(svc)
+	if entry := resolver.ResolveService("web-80"); entry != nil {
+		t.Errorf("got entry %s after RemoveService", entry.SourceHostName())
+	}
+	if len(resolver.Backends()) != 0 {
+		t.Errorf("got %d backends after RemoveService, want 0", len(resolver.Backends()))
+	}
+
+	// the known pod backs the service again once it is added back
+	resolver.AddService(svc)
+	if resolver.ResolveBackend("web-80") == nil {
+		t.Error("no backend after the service is added again")
+	}
+}
+
+func TestPortForwardResolverAnnotatedHostNames(t *testing.T) {
+	resolver := NewPortForwardResolver()
+	svc := newWebService()
+	svc.Annotations = map[string]string{HostNamesAnnotation: "Web.Example, ../../etc/x"}
+	resolver.AddService(svc)
+
+	if resolver.ResolveService("web.example") == nil {
+		t.Error("web.example does not resolve")
+	}
+	if entry := resolver.ResolveService("../../etc/x"); entry != nil {
+		t.Errorf("invalid host name resolves to %s", entry.SourceHostName())
+	}
+}
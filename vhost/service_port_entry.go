@@ -2,11 +2,19 @@ package vhost
 
 import (
 	"strconv"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// HostNamesAnnotation lists extra host names of a service, separated by
+// commas. A host name applies to the first port of the service unless it
+// is followed by "=" and a port name or number, e.g. "api.local,grpc=grpc".
+const HostNamesAnnotation = "vhost.josudoey/hostnames"
+
 type ServicePortEntry struct {
 	Service     corev1.Service
 	ServicePort corev1.ServicePort
@@ -28,3 +36,84 @@ func (s *ServicePortEntry) TargetHostPort() string {
 func (s *ServicePortEntry) SourceHostName() string {
 	return s.Service.GetName() + "-" + strconv.Itoa(int(s.ServicePort.Port))
 }
+
+// SessionAffinityTimeout returns how long a client stays on the same pod,
+// or zero when the service does not use ClientIP session affinity.
+func (s *ServicePortEntry) SessionAffinityTimeout() time.Duration {
+	if s.Service.Spec.SessionAffinity != corev1.ServiceAffinityClientIP {
+		return 0
+	}
+	seconds := corev1.DefaultClientIPServiceAffinitySeconds
+	config := s.Service.Spec.SessionAffinityConfig
+	if config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+		seconds = *config.ClientIP.TimeoutSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// SourceHostNames returns every host name of the service port: the
+// name-port host name, the name-portname host name for named ports and
+// the host names of the HostNamesAnnotation.
+func (s *ServicePortEntry) SourceHostNames() []string {
+	names := []string{s.SourceHostName()}
+	if s.ServicePort.Name != "" {
+		names = append(names, s.Service.GetName()+"-"+s.ServicePort.Name)
+	}
+	return append(names, s.annotatedHostNames()...)
+}
+
+// annotatedHostNames returns the valid host names of the annotation. The
+// names end up in file names, e.g. of recordings and sockets, so anything
+// but a DNS subdomain is dropped.
+func (s *ServicePortEntry) annotatedHostNames() []string {
+	names := []string{}
+	for _, name := range s.annotationHostNames() {
+		if len(validation.IsDNS1123Subdomain(name)) == 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// InvalidHostNames returns the host names of the HostNamesAnnotation that
+// are not DNS subdomains and are ignored.
+func (s *ServicePortEntry) InvalidHostNames() []string {
+	names := []string{}
+	for _, name := range s.annotationHostNames() {
+		if len(validation.IsDNS1123Subdomain(name)) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *ServicePortEntry) annotationHostNames() []string {
+	names := []string{}
+	value := s.Service.GetAnnotations()[HostNamesAnnotation]
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, port := item, ""
+		if i := strings.Index(item, "="); i >= 0 {
+			name, port = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		if !s.matchPort(port) {
+			continue
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	return names
+}
+
+func (s *ServicePortEntry) matchPort(port string) bool {
+	if port == "" {
+		ports := s.Service.Spec.Ports
+		return len(ports) > 0 && ports[0].Port == s.ServicePort.Port
+	}
+	if port == s.ServicePort.Name {
+		return true
+	}
+	return port == strconv.Itoa(int(s.ServicePort.Port))
+}
@@ -0,0 +1,45 @@
+package vhost
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// serviceProxy sends req through the services/proxy subresource of the API
+// server, see https://kubernetes.io/docs/tasks/access-application-cluster/access-cluster-services/#manually-constructing-apiserver-proxy-urls
+func (t *portForwardTransport) serviceProxy(entry *ServicePortEntry, req *http.Request) (*http.Response, error) {
+	t.proxyOnce.Do(func() {
+		t.proxyTransport, t.proxyErr = rest.TransportFor(t.config)
+	})
+	if t.proxyErr != nil {
+		return nil, t.proxyErr
+	}
+
+	namespace := entry.Service.GetNamespace()
+	if namespace == "" {
+		namespace = t.namespace
+	}
+	u := t.client.Get().
+		Namespace(namespace).
+		Resource("services").
+		Name(entry.Service.GetName() + ":" + strconv.Itoa(int(entry.ServicePort.Port))).
+		SubResource("proxy").
+		URL()
+	base, rawBase := strings.TrimSuffix(u.Path, "/"), strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = base + "/" + strings.TrimPrefix(req.URL.Path, "/")
+	if req.URL.RawPath != "" {
+		u.RawPath = rawBase + "/" + strings.TrimPrefix(req.URL.RawPath, "/")
+	}
+	u.RawQuery = req.URL.RawQuery
+
+	out := req.Clone(req.Context())
+	out.URL = u
+	out.Host = ""
+	out.RequestURI = ""
+	// the API server credentials are set by the transport
+	out.Header.Del("Authorization")
+	return t.proxyTransport.RoundTrip(out)
+}
@@ -0,0 +1,50 @@
+package vhost
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/josudoey/kube/kubetest"
+)
+
+func TestServiceProxyFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []string
+	}{
+		{name: "no ready pod"},
+		{name: "port-forward denied", pods: []string{"web-0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, config := newAPIClient(t, func(w http.ResponseWriter, req *http.Request) {
+				if denyPortForward(w, req) {
+					return
+				}
+				w.Write([]byte(req.URL.Path))
+			})
+			resolver := NewPortForwardResolver()
+			resolver.ServiceProxyFallback = true
+			resolver.AddService(newWebService())
+			for _, name := range tt.pods {
+				resolver.AddPod(newWebPod(name, kubetest.WithPodReady()))
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://web-80/status", nil)
+			res, err := resolver.NewRoundTripper(client, config, "default").RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "/api/v1/namespaces/default/services/web:80/proxy/status"; string(body) != want {
+				t.Errorf("got %q proxied, want %q", body, want)
+			}
+		})
+	}
+}
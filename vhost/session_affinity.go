@@ -0,0 +1,108 @@
+package vhost
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCookieAffinityTimeout is how long a cookie keeps a client on the
+// same pod when the service itself does not set an affinity timeout.
+const DefaultCookieAffinityTimeout = 3 * time.Hour
+
+// affinitySweepInterval is how often Set drops the expired records, e.g.
+// of clients that got a cookie and never came back.
+const affinitySweepInterval = time.Minute
+
+type affinityKey struct {
+	entry  *ServicePortEntry
+	client string
+}
+
+type affinityRecord struct {
+	podName string
+	expires time.Time
+}
+
+// SessionAffinity remembers which pod served a client for a service port.
+type SessionAffinity struct {
+	m sync.Map
+
+	sweepLock sync.Mutex
+	lastSweep time.Time
+}
+
+func (p *SessionAffinity) Get(entry *ServicePortEntry, client string, now time.Time) (string, bool) {
+	key := affinityKey{entry: entry, client: client}
+	v, ok := p.m.Load(key)
+	if !ok {
+		return "", false
+	}
+	record, _ := v.(*affinityRecord)
+	if now.After(record.expires) {
+		p.m.Delete(key)
+		return "", false
+	}
+	return record.podName, true
+}
+
+func (p *SessionAffinity) Set(entry *ServicePortEntry, client string, podName string, expires time.Time) {
+	key := affinityKey{entry: entry, client: client}
+	p.m.Store(key, &affinityRecord{
+		podName: podName,
+		expires: expires,
+	})
+
+	now := time.Now()
+	p.sweepLock.Lock()
+	due := now.Sub(p.lastSweep) >= affinitySweepInterval
+	if due {
+		p.lastSweep = now
+	}
+	p.sweepLock.Unlock()
+	if due {
+		p.Sweep(now)
+	}
+}
+
+// Sweep deletes the records that expired before now.
+func (p *SessionAffinity) Sweep(now time.Time) {
+	p.m.Range(func(k, v interface{}) bool {
+		record, _ := v.(*affinityRecord)
+		if now.After(record.expires) {
+			p.m.Delete(k)
+		}
+		return true
+	})
+}
+
+func (p *SessionAffinity) DeleteByName(podName string) {
+	p.m.Range(func(k, v interface{}) bool {
+		record, _ := v.(*affinityRecord)
+		if record.podName == podName {
+			p.m.Delete(k)
+		}
+		return true
+	})
+}
+
+// clientIP returns the host part of a remote address, which is what
+// kube-proxy keys ClientIP affinity on.
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func newAffinityKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,302 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// PodCondition reports whether the pods of a wait are done and how far
+// they are, e.g. "2/3 ready", for the error of a wait that ends first. An
+// error ends the wait early, e.g. when a pod failed that should succeed.
+type PodCondition func(pods []*corev1.Pod) (bool, string, error)
+
+// PodsReady is met when at least n pods are ready.
+func PodsReady(n int) PodCondition {
+	return func(pods []*corev1.Pod) (bool, string, error) {
+		ready := countPods(pods, IsPodReady)
+		return ready >= n, fmt.Sprintf("%d/%d ready", ready, n), nil
+	}
+}
+
+// AllPodsReady is met when there are pods and all of them are ready.
+func AllPodsReady() PodCondition {
+	return func(pods []*corev1.Pod) (bool, string, error) {
+		ready := countPods(pods, IsPodReady)
+		return len(pods) > 0 && ready == len(pods), fmt.Sprintf("%d/%d ready", ready, len(pods)), nil
+	}
+}
+
+// ContainersStarted is met when there are pods and every container of
+// them has started.
+func ContainersStarted() PodCondition {
+	return func(pods []*corev1.Pod) (bool, string, error) {
+		started := countPods(pods, containersStarted)
+		return len(pods) > 0 && started == len(pods), fmt.Sprintf("%d/%d started", started, len(pods)), nil
+	}
+}
+
+func containersStarted(pod *corev1.Pod) bool {
+	if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Started == nil || !*status.Started {
+			return false
+		}
+	}
+	return true
+}
+
+// PodsDeleted is met when no pod is left.
+func PodsDeleted() PodCondition {
+	return func(pods []*corev1.Pod) (bool, string, error) {
+		status := fmt.Sprintf("%d pods not deleted", len(pods))
+		if len(pods) == 1 {
+			status = "1 pod not deleted"
+		}
+		return len(pods) == 0, status, nil
+	}
+}
+
+// PodsSucceeded is met when there are pods and all of them succeeded. It
+// fails as soon as a pod failed.
+func PodsSucceeded() PodCondition {
+	return func(pods []*corev1.Pod) (bool, string, error) {
+		for _, pod := range pods {
+			if pod.Status.Phase == corev1.PodFailed {
+				return false, "", fmt.Errorf("pod %s failed: %s", pod.Name, PodNotReadyReason(pod))
+			}
+		}
+		return inPhase(pods, corev1.PodSucceeded, "succeeded")
+	}
+}
+
+// PodsFailed is met when there are pods and all of them failed.
+func PodsFailed() PodCondition {
+	return func(pods []*corev1.Pod) (bool, string, error) {
+		return inPhase(pods, corev1.PodFailed, "failed")
+	}
+}
+
+func inPhase(pods []*corev1.Pod, phase corev1.PodPhase, name string) (bool, string, error) {
+	n := countPods(pods, func(pod *corev1.Pod) bool {
+		return pod.Status.Phase == phase
+	})
+	return len(pods) > 0 && n == len(pods), fmt.Sprintf("%d/%d %s", n, len(pods), name), nil
+}
+
+func countPods(pods []*corev1.Pod, match func(*corev1.Pod) bool) int {
+	n := 0
+	for _, pod := range pods {
+		if match(pod) {
+			n++
+		}
+	}
+	return n
+}
+
+// diagnoseTimeout bounds the lookup of probe failures once a wait ended.
+const diagnoseTimeout = 5 * time.Second
+
+// WaitError is returned when a wait ends before its condition is met. It
+// tells how far the pods got and explains for each pod why it is not
+// ready.
+type WaitError struct {
+	Err error
+	// Pods is the number of pods when the wait ended.
+	Pods int
+	// Status is how far the pods got, as the condition reported it.
+	Status  string
+	Reasons []string
+}
+
+func (e *WaitError) Error() string {
+	msg := fmt.Sprintf("waiting for pods: %v", e.Err)
+	if e.Pods == 0 {
+		msg += ": no pods found"
+	} else if e.Status != "" {
+		msg += ": " + e.Status
+	}
+	if len(e.Reasons) == 0 {
+		return msg
+	}
+	return msg + "\n  " + strings.Join(e.Reasons, "\n  ")
+}
+
+func (e *WaitError) Unwrap() error {
+	return e.Err
+}
+
+// PodNotReadyReason explains why pod is not ready from its phase,
+// conditions and container states.
+func PodNotReadyReason(pod *corev1.Pod) string {
+	reasons := []string{string(pod.Status.Phase)}
+	if pod.DeletionTimestamp != nil {
+		reasons = append(reasons, "terminating")
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue || condition.Reason == "" {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s %s", condition.Type, condition.Reason, condition.Message))
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		state := status.State
+		switch {
+		case state.Waiting != nil:
+			reasons = append(reasons, fmt.Sprintf("container %s waiting: %s %s", status.Name, state.Waiting.Reason, state.Waiting.Message))
+		case state.Terminated != nil && state.Terminated.ExitCode != 0:
+			reasons = append(reasons, fmt.Sprintf("container %s terminated: %s exit code %d %s", status.Name, state.Terminated.Reason, state.Terminated.ExitCode, state.Terminated.Message))
+		case state.Running != nil && !status.Ready:
+			reasons = append(reasons, fmt.Sprintf("container %s running but not ready", status.Name))
+		}
+		if status.RestartCount > 0 {
+			reasons = append(reasons, fmt.Sprintf("container %s restarted %d times", status.Name, status.RestartCount))
+		}
+	}
+	for i := range reasons {
+		reasons[i] = strings.TrimSpace(reasons[i])
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// probeFailure returns the message of the latest failed probe event of
+// pod, when client can list events.
+func probeFailure(ctx context.Context, client coreclient.PodsGetter, pod *corev1.Pod) string {
+	events, ok := client.(coreclient.EventsGetter)
+	if !ok {
+		return ""
+	}
+	selector := fields.Set{
+		"involvedObject.name": pod.Name,
+		"involvedObject.uid":  string(pod.UID),
+		"reason":              "Unhealthy",
+	}.AsSelector().String()
+	list, err := events.Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil || len(list.Items) == 0 {
+		return ""
+	}
+	latest := list.Items[0]
+	for _, event := range list.Items[1:] {
+		if event.LastTimestamp.After(latest.LastTimestamp.Time) {
+			latest = event
+		}
+	}
+	return latest.Message
+}
+
+// WaitForPods waits until condition is met by the pods of the namespace
+// and selectors of opts. WithTimeout bounds the wait. When the wait ends
+// first, a *WaitError explains why each pod is not ready.
+func WaitForPods(ctx context.Context, client coreclient.PodsGetter, condition PodCondition, opts ...KubeOption) ([]*corev1.Pod, error) {
+	o := NewKubeOptions(opts)
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	podList, err := GetPodList(ctx, client, opts...)
+	if err != nil {
+		return nil, err
+	}
+	pods := map[string]*corev1.Pod{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		pods[podKey(pod)] = pod
+	}
+	current := func() []*corev1.Pod {
+		items := []*corev1.Pod{}
+		for _, pod := range pods {
+			items = append(items, pod)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return podKey(items[i]) < podKey(items[j])
+		})
+		return items
+	}
+	done, lastStatus, err := condition(current())
+	if done || err != nil {
+		return current(), err
+	}
+
+	watcher, err := GetRetryPodWatcherFromList(ctx, client, podList, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case e, ok := <-watcher.ResultChan():
+			if !ok {
+				return current(), waitError(client, ctx.Err(), current(), lastStatus)
+			}
+			pod := GetPod(e.Object)
+			if pod == nil {
+				continue
+			}
+			if e.Type == watch.Deleted {
+				delete(pods, podKey(pod))
+			} else {
+				pods[podKey(pod)] = pod
+			}
+			done, status, err := condition(current())
+			if done || err != nil {
+				return current(), err
+			}
+			lastStatus = status
+		case <-ctx.Done():
+			return current(), waitError(client, ctx.Err(), current(), lastStatus)
+		}
+	}
+}
+
+// WaitForPodsOfObject waits until condition is met by the pods selected
+// by a service or workload.
+func WaitForPodsOfObject(ctx context.Context, client coreclient.PodsGetter, object runtime.Object, condition PodCondition, opts ...KubeOption) ([]*corev1.Pod, error) {
+	object = typed(object)
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return nil, err
+	}
+	_, selector, err := SelectorsForObject(object)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		WithNamespace(accessor.GetNamespace()),
+		WithLabelSelector(selector.String()),
+	)
+	return WaitForPods(ctx, client, condition, opts...)
+}
+
+func waitError(client coreclient.PodsGetter, err error, pods []*corev1.Pod, status string) error {
+	// the wait context is over, diagnose with a short one of its own
+	diagCtx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+	defer cancel()
+	reasons := []string{}
+	for _, pod := range pods {
+		if IsPodReady(pod) {
+			continue
+		}
+		reason := fmt.Sprintf("pod %s: %s", pod.Name, PodNotReadyReason(pod))
+		if message := probeFailure(diagCtx, client, pod); message != "" {
+			reason += ", " + message
+		}
+		reasons = append(reasons, reason)
+	}
+	return &WaitError{Err: err, Pods: len(pods), Status: status, Reasons: reasons}
+}
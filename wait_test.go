@@ -0,0 +1,110 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josudoey/kube/kubetest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestWaitForPodsWatchesUntilReady(t *testing.T) {
+	labels := map[string]string{"app": "web"}
+	client := kubetest.NewClientset(
+		kubetest.NewPod("web-0", kubetest.WithPodLabels(labels), kubetest.WithPodReady()),
+		kubetest.NewPod("web-1", kubetest.WithPodLabels(labels), kubetest.WithPodNotReady("ContainerCreating", "")),
+	)
+	script := kubetest.NewWatchScript(t, client, "pods")
+	go script.Modify(kubetest.NewPod("web-1", kubetest.WithPodLabels(labels), kubetest.WithPodReady()))
+
+	pods, err := WaitForPods(context.Background(), client.CoreV1(), AllPodsReady(),
+		WithLabelSelector("app=web"),
+		WithTimeout(kubetest.WatchTimeout),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 2 || pods[0].Name != "web-0" || pods[1].Name != "web-1" {
+		t.Errorf("got %d pods, want web-0 and web-1", len(pods))
+	}
+	if actions := script.Actions(); len(actions) != 1 || actions[0].GetWatchRestrictions().Labels.String() != "app=web" {
+		t.Errorf("got watches %v, want one of app=web", actions)
+	}
+}
+
+func TestWaitForPodsMetByList(t *testing.T) {
+	client := kubetest.NewClientset()
+	script := kubetest.NewWatchScript(t, client, "pods")
+
+	if _, err := WaitForPods(context.Background(), client.CoreV1(), PodsDeleted()); err != nil {
+		t.Fatal(err)
+	}
+	if actions := script.Actions(); len(actions) != 0 {
+		t.Errorf("got %d watches, want none", len(actions))
+	}
+}
+
+func TestWaitForPodsTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		pods      []*corev1.Pod
+		condition PodCondition
+		want      []string
+	}{
+		{
+			name:      "not enough ready",
+			pods:      []*corev1.Pod{kubetest.NewPod("web-0", kubetest.WithPodReady()), kubetest.NewPod("web-1", kubetest.WithPodNotReady("ImagePullBackOff", "pull denied"))},
+			condition: PodsReady(3),
+			want:      []string{"1/3 ready", "pod web-1: Pending", "container app waiting: ImagePullBackOff pull denied"},
+		},
+		{
+			name:      "all ready but not deleted",
+			pods:      []*corev1.Pod{kubetest.NewPod("web-0", kubetest.WithPodReady())},
+			condition: PodsDeleted(),
+			want:      []string{"1 pod not deleted"},
+		},
+		{
+			name:      "no pods",
+			condition: AllPodsReady(),
+			want:      []string{"no pods found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := kubetest.NewClientset()
+			for _, pod := range tt.pods {
+				if err := client.Tracker().Add(pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+			kubetest.NewWatchScript(t, client, "pods")
+
+			_, err := WaitForPods(context.Background(), client.CoreV1(), tt.condition, WithTimeout(50*time.Millisecond))
+			var waitErr *WaitError
+			if !errors.As(err, &waitErr) {
+				t.Fatalf("got %v, want a *WaitError", err)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %v, want it to wrap the deadline", waitErr.Err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got %q, want it to contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForPodsFailsFast(t *testing.T) {
+	client := kubetest.NewClientset(kubetest.NewPod("job-0", kubetest.WithPodPhase(corev1.PodFailed)))
+	kubetest.NewWatchScript(t, client, "pods")
+
+	_, err := WaitForPods(context.Background(), client.CoreV1(), PodsSucceeded(), WithTimeout(kubetest.WatchTimeout))
+	if err == nil || !strings.Contains(err.Error(), "pod job-0 failed") {
+		t.Errorf("got %v, want job-0 failed", err)
+	}
+}